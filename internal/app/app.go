@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -14,9 +15,15 @@ import (
 	"gitlab.snapp.ir/snappcloud/health_exporter/internal/metrics"
 	"gitlab.snapp.ir/snappcloud/health_exporter/internal/probe"
 	dnsprobe "gitlab.snapp.ir/snappcloud/health_exporter/internal/probe/dns"
+	esprobe "gitlab.snapp.ir/snappcloud/health_exporter/internal/probe/elasticsearch"
+	execprobe "gitlab.snapp.ir/snappcloud/health_exporter/internal/probe/exec"
 	httpprobe "gitlab.snapp.ir/snappcloud/health_exporter/internal/probe/http"
 	icmpprobe "gitlab.snapp.ir/snappcloud/health_exporter/internal/probe/icmp"
 	k8sprobe "gitlab.snapp.ir/snappcloud/health_exporter/internal/probe/k8s"
+	promprobe "gitlab.snapp.ir/snappcloud/health_exporter/internal/probe/prometheus"
+	s3probe "gitlab.snapp.ir/snappcloud/health_exporter/internal/probe/s3"
+	sshprobe "gitlab.snapp.ir/snappcloud/health_exporter/internal/probe/ssh"
+	twampprobe "gitlab.snapp.ir/snappcloud/health_exporter/internal/probe/twamp"
 	"gitlab.snapp.ir/snappcloud/health_exporter/internal/server"
 )
 
@@ -30,6 +37,14 @@ type App struct {
 		dns  *metrics.DNS
 		icmp *metrics.ICMP
 		k8s  *metrics.K8S
+		ssh  *metrics.SSH
+		exec *metrics.Exec
+		prom *metrics.Prometheus
+		es   *metrics.Elasticsearch
+		s3   *metrics.S3
+
+		traceroute *metrics.Traceroute
+		twamp      *metrics.TWAMP
 	}
 }
 
@@ -43,6 +58,13 @@ func New(cfg config.Config) (*App, error) {
 	app.metrics.dns = metrics.NewDNS(app.reg)
 	app.metrics.icmp = metrics.NewICMP(app.reg)
 	app.metrics.k8s = metrics.NewK8S(app.reg)
+	app.metrics.ssh = metrics.NewSSH(app.reg)
+	app.metrics.exec = metrics.NewExec(app.reg)
+	app.metrics.prom = metrics.NewPrometheus(app.reg)
+	app.metrics.es = metrics.NewElasticsearch(app.reg)
+	app.metrics.s3 = metrics.NewS3(app.reg)
+	app.metrics.traceroute = metrics.NewTraceroute(app.reg)
+	app.metrics.twamp = metrics.NewTWAMP(app.reg)
 
 	if err := app.buildProbes(); err != nil {
 		return nil, err
@@ -77,15 +99,93 @@ func (a *App) buildProbes() error {
 	}
 
 	for _, target := range a.cfg.Targets.DNS {
-		klog.Infof("Configuring DNS probe %q domain=%s rps=%.2f server=%s:%d", target.Name, target.Domain, target.RPS, target.ServerIP, target.ServerPort)
-		a.probes = append(a.probes, dnsprobe.New(target, a.metrics.dns))
+		domain := target.Domain
+		if target.DomainsFile != "" {
+			domain = target.DomainsFile
+		}
+		klog.Infof("Configuring DNS probe %q domain=%s rps=%.2f servers=%s transport=%s random_subdomain=%t", target.Name, domain, target.RPS, strings.Join(target.Servers, ","), target.Transport, target.RandomSubdomain)
+		p, err := dnsprobe.New(target, a.metrics.dns)
+		if err != nil {
+			return fmt.Errorf("dns target %q: %w", target.Name, err)
+		}
+		a.probes = append(a.probes, p)
+	}
+
+	for _, target := range a.cfg.Targets.DNSZones {
+		klog.Infof("Configuring DNS zone probe %q zone=%s rps=%.2f resolver=%s timeout=%s", target.Name, target.Zone, target.RPS, target.Resolver, target.Timeout)
+		a.probes = append(a.probes, dnsprobe.NewZone(target, a.metrics.dns))
+	}
+
+	for _, target := range a.cfg.Targets.DNSCompare {
+		klog.Infof("Configuring DNS compare probe %q domain=%s type=%s rps=%.2f servers=%s", target.Name, target.Domain, target.RecordType, target.RPS, strings.Join(target.Servers, ","))
+		a.probes = append(a.probes, dnsprobe.NewCompare(target, a.metrics.dns))
 	}
 
 	for _, target := range a.cfg.Targets.ICMP {
-		klog.Infof("Configuring ICMP probe %q host=%s rps=%.2f ttl=%d timeout=%s", target.Name, target.Host, target.RPS, target.TTL, target.Timeout)
+		klog.Infof("Configuring ICMP probe %q host=%s rps=%.2f ttl=%d timeout=%s count=%d interval=%s privileged=%t ip_version=%s size=%d dont_fragment=%t pmtu_discovery=%t", target.Name, target.Host, target.RPS, target.TTL, target.Timeout, target.Count, target.Interval, target.Privileged, target.IPVersion, target.Size, target.DontFragment, target.PMTUDiscovery)
 		a.probes = append(a.probes, icmpprobe.New(target, a.metrics.icmp))
 	}
 
+	for _, target := range a.cfg.Targets.Traceroute {
+		klog.Infof("Configuring traceroute probe %q host=%s rps=%.2f protocol=%s port=%d max_hops=%d count=%d timeout=%s", target.Name, target.Host, target.RPS, target.Protocol, target.Port, target.MaxHops, target.Count, target.Timeout)
+		a.probes = append(a.probes, icmpprobe.NewTraceroute(target, a.metrics.traceroute))
+	}
+
+	for _, target := range a.cfg.Targets.TWAMP {
+		klog.Infof("Configuring TWAMP probe %q address=%s rps=%.2f count=%d interval=%s timeout=%s", target.Name, target.Address, target.RPS, target.Count, target.Interval, target.Timeout)
+		a.probes = append(a.probes, twampprobe.New(target, a.metrics.twamp))
+	}
+
+	if a.cfg.Reflector.Listen != "" {
+		klog.Infof("Configuring TWAMP reflector listen=%s allow=%s", a.cfg.Reflector.Listen, strings.Join(a.cfg.Reflector.Allow, ","))
+		r, err := twampprobe.NewReflector(a.cfg.Reflector, a.metrics.twamp)
+		if err != nil {
+			return fmt.Errorf("reflector: %w", err)
+		}
+		a.probes = append(a.probes, r)
+	}
+
+	for _, target := range a.cfg.Targets.SSH {
+		klog.Infof("Configuring SSH probe %q host=%s:%d rps=%.2f timeout=%s", target.Name, target.Host, target.Port, target.RPS, target.Timeout)
+		p, err := sshprobe.New(target, a.metrics.ssh)
+		if err != nil {
+			return fmt.Errorf("ssh target %q: %w", target.Name, err)
+		}
+		a.probes = append(a.probes, p)
+	}
+
+	for _, target := range a.cfg.Targets.Exec {
+		klog.Infof("Configuring exec probe %q command=%s rps=%.2f timeout=%s max_concurrency=%d", target.Name, target.Command, target.RPS, target.Timeout, target.MaxConcurrency)
+		a.probes = append(a.probes, execprobe.New(target, a.metrics.exec))
+	}
+
+	for _, target := range a.cfg.Targets.Prometheus {
+		klog.Infof("Configuring Prometheus probe %q url=%s rps=%.2f timeout=%s assertions=%d", target.Name, target.URL, target.RPS, target.Timeout, len(target.Assertions))
+		p, err := promprobe.New(target, a.metrics.prom)
+		if err != nil {
+			return fmt.Errorf("prometheus target %q: %w", target.Name, err)
+		}
+		a.probes = append(a.probes, p)
+	}
+
+	for _, target := range a.cfg.Targets.Elasticsearch {
+		klog.Infof("Configuring Elasticsearch probe %q url=%s rps=%.2f timeout=%s", target.Name, target.URL, target.RPS, target.Timeout)
+		p, err := esprobe.New(target, a.metrics.es)
+		if err != nil {
+			return fmt.Errorf("elasticsearch target %q: %w", target.Name, err)
+		}
+		a.probes = append(a.probes, p)
+	}
+
+	for _, target := range a.cfg.Targets.S3 {
+		klog.Infof("Configuring S3 probe %q endpoint=%s bucket=%s rps=%.2f timeout=%s", target.Name, target.Endpoint, target.Bucket, target.RPS, target.Timeout)
+		p, err := s3probe.New(target, a.metrics.s3)
+		if err != nil {
+			return fmt.Errorf("s3 target %q: %w", target.Name, err)
+		}
+		a.probes = append(a.probes, p)
+	}
+
 	if a.cfg.Targets.K8S.Enabled {
 		if err := a.setupK8SProbes(); err != nil {
 			return err
@@ -99,14 +199,24 @@ func (a *App) setupK8SProbes() error {
 	klog.Infof("Kubernetes probing enabled")
 	metrics.RegisterClientGoMetrics(a.reg)
 
-	client, err := k8sprobe.NewClient()
-	if err != nil {
-		return fmt.Errorf("k8s client: %w", err)
-	}
+	names := map[string]struct{}{}
+	for _, cluster := range a.cfg.Targets.K8S.AllClusters() {
+		klog.Infof("Configuring K8S cluster %q kubeconfig=%s context=%s", cluster.Name, cluster.Kubeconfig, cluster.Context)
+		client, err := k8sprobe.NewClient(cluster)
+		if err != nil {
+			return fmt.Errorf("k8s cluster %q: %w", cluster.Name, err)
+		}
+		// The top-level cluster takes its name from its context, which may
+		// be one of the named clusters.
+		if _, ok := names[client.Name()]; ok {
+			return fmt.Errorf("k8s cluster %q: name is already used by the top-level cluster", client.Name())
+		}
+		names[client.Name()] = struct{}{}
 
-	for _, target := range a.cfg.Targets.K8S.SimpleProbe {
-		klog.Infof("Configuring K8S simple probe namespace=%s rps=%.2f", target.NameSpace, target.RPS)
-		a.probes = append(a.probes, k8sprobe.NewSimpleProbe(client, target, a.metrics.k8s))
+		for _, target := range cluster.SimpleProbe {
+			klog.Infof("Configuring K8S simple probe cluster=%s namespace=%s rps=%.2f", client.Name(), target.NameSpace, target.RPS)
+			a.probes = append(a.probes, k8sprobe.NewSimpleProbe(client, target, a.metrics.k8s))
+		}
 	}
 	return nil
 }
@@ -3,11 +3,18 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net"
+	"net/netip"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/miekg/dns"
 	"gopkg.in/yaml.v3"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/exposition"
 )
 
 const (
@@ -15,12 +22,75 @@ const (
 	defaultHTTPTimeout = 3 * time.Second
 	defaultDNSTimeout  = 2 * time.Second
 	defaultICMPTimeout = 2 * time.Second
+	defaultSSHTimeout  = 3 * time.Second
+	defaultExecTimeout = 10 * time.Second
+	defaultPromTimeout = 5 * time.Second
+	defaultESTimeout   = 5 * time.Second
+	defaultS3Timeout   = 5 * time.Second
 	defaultK8sRPS      = 1.0
+	defaultK8sTimeout  = 5 * time.Second
+
+	// Echoes of an ICMP burst are spaced like ping(8) does by default.
+	defaultICMPInterval = time.Second
+	// The default echo payload of ping(8) and the common Ethernet MTU.
+	defaultICMPSize   = 56
+	defaultICMPMaxMTU = 1500
+	// Path MTU changes rarely and a search costs a dozen echoes.
+	defaultICMPPMTUInterval = 10 * time.Minute
+
+	// Traceroute flows bind source ports below the default Linux ephemeral
+	// port range.
+	defaultTracerouteSourcePort = 32000
+
+	// The well-known TWAMP port, also used by TWAMP-light reflectors.
+	defaultTWAMPPort     = 862
+	defaultTWAMPInterval = 100 * time.Millisecond
+
+	// The root zone KSK-2017 trust anchor, as published by IANA.
+	defaultDNSSECTrustAnchor = ". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBB683457104237C7F8EC8D"
+)
+
+const (
+	DNSTransportUDP = "udp"
+	DNSTransportTCP = "tcp"
+	DNSTransportDoT = "dot"
+	DNSTransportDoH = "doh"
+	DNSTransportDoQ = "doq"
+)
+
+const (
+	ICMPIPVersion4    = "4"
+	ICMPIPVersion6    = "6"
+	ICMPIPVersionDual = "dual"
+)
+
+// maxFlows bounds the flows of ECMP probing, each of which gets a socket of
+// its own.
+const maxFlows = 256
+
+const (
+	TracerouteProtocolICMP = "icmp"
+	TracerouteProtocolUDP  = "udp"
+	TracerouteProtocolTCP  = "tcp"
 )
 
 type Config struct {
 	Listen  string  `yaml:"listen"`
 	Targets Targets `yaml:"targets"`
+
+	Reflector ReflectorConfig `yaml:"reflector"`
+}
+
+// ReflectorConfig runs a TWAMP-light reflector that answers the twamp targets
+// of exporters elsewhere. It is off unless Listen is set.
+type ReflectorConfig struct {
+	Listen string `yaml:"listen"`
+	// Allow lists the addresses or CIDRs of the senders to answer. It is
+	// required; 0.0.0.0/0 and ::/0 answer anyone.
+	Allow []string `yaml:"allow"`
+	// ClockSynchronized claims a synchronized clock even if the kernel does
+	// not know about it, e.g. when it is disciplined by PTP.
+	ClockSynchronized bool `yaml:"clock_synchronized"`
 }
 
 type Targets struct {
@@ -28,6 +98,16 @@ type Targets struct {
 	DNS  []DNSTarget  `yaml:"dns"`
 	K8S  K8STarget    `yaml:"k8s"`
 	ICMP []ICMPTarget `yaml:"icmp"`
+	SSH  []SSHTarget  `yaml:"ssh"`
+	Exec []ExecTarget `yaml:"exec"`
+
+	Prometheus    []PrometheusTarget    `yaml:"prometheus"`
+	Elasticsearch []ElasticsearchTarget `yaml:"elasticsearch"`
+	S3            []S3Target            `yaml:"s3"`
+	DNSZones      []DNSZoneTarget       `yaml:"dns_zones"`
+	DNSCompare    []DNSCompareTarget    `yaml:"dns_compare"`
+	Traceroute    []TracerouteTarget    `yaml:"traceroute"`
+	TWAMP         []TWAMPTarget         `yaml:"twamp"`
 }
 
 type HTTPTarget struct {
@@ -39,6 +119,45 @@ type HTTPTarget struct {
 	DisableKeepAlives bool          `yaml:"disable_keepalives"`
 	H2cEnabled        bool          `yaml:"h2c_enabled"`
 	Host              string        `yaml:"host"`
+
+	SocketOptions `yaml:",inline"`
+}
+
+// SocketOptions pick how a probe's packets leave the host: the QoS class they
+// are marked with and the address or interface they are sent from.
+type SocketOptions struct {
+	// TOS is the whole IPv4 TOS / IPv6 traffic class byte; DSCP sets only
+	// its upper six bits.
+	TOS       int    `yaml:"tos"`
+	DSCP      int    `yaml:"dscp"`
+	SourceIP  string `yaml:"source_ip"`
+	Interface string `yaml:"interface"`
+}
+
+// TrafficClass returns the TOS byte to mark packets with, or 0 to leave it.
+func (o SocketOptions) TrafficClass() int {
+	if o.DSCP != 0 {
+		return o.DSCP << 2
+	}
+	return o.TOS
+}
+
+func (o SocketOptions) validate() error {
+	if o.TOS < 0 || o.TOS > 255 {
+		return errors.New("tos should be between 0 and 255")
+	}
+	if o.DSCP < 0 || o.DSCP > 63 {
+		return errors.New("dscp should be between 0 and 63")
+	}
+	if o.TOS != 0 && o.DSCP != 0 {
+		return errors.New("tos and dscp are mutually exclusive")
+	}
+	if o.SourceIP != "" {
+		if _, err := netip.ParseAddr(o.SourceIP); err != nil {
+			return fmt.Errorf("invalid source_ip %q: %w", o.SourceIP, err)
+		}
+	}
+	return nil
 }
 
 type DNSTarget struct {
@@ -49,16 +168,128 @@ type DNSTarget struct {
 	ServerIP   string        `yaml:"server_ip"`
 	ServerPort int           `yaml:"server_port"`
 	Timeout    time.Duration `yaml:"timeout"`
+	Expect     DNSExpect     `yaml:"expect"`
+
+	Transport     string `yaml:"transport"`
+	TLSServerName string `yaml:"tls_server_name"`
+	CAFile        string `yaml:"ca_file"`
+	TLSSkipVerify bool   `yaml:"tls_skip_verify"`
+	DoHPath       string `yaml:"doh_path"`
+
+	DNSSEC DNSSEC  `yaml:"dnssec"`
+	EDNS   DNSEDNS `yaml:"edns"`
+
+	DisableTCPFallback bool `yaml:"disable_tcp_fallback"`
+
+	// Servers lists every resolver to query as host or host:port. It is
+	// filled from server_ip when empty; server_ip "all" selects every
+	// nameserver in resolv.conf.
+	Servers       []string `yaml:"servers"`
+	Search        bool     `yaml:"search"`
+	SearchDomains []string `yaml:"search_domains"`
+	NDots         int      `yaml:"ndots"`
+
+	// DomainsFile lists one domain per line to rotate through instead of
+	// Domain. Blank lines and lines starting with # are ignored.
+	DomainsFile string `yaml:"domains_file"`
+	// RandomSubdomain prefixes every query with a random label so the
+	// resolver has to miss its cache.
+	RandomSubdomain bool `yaml:"random_subdomain"`
+
+	// QType is RecordType resolved by validate.
+	QType uint16 `yaml:"-"`
+}
+
+type DNSEDNS struct {
+	UDPSize      uint16 `yaml:"udp_size"`
+	ClientSubnet string `yaml:"client_subnet"`
+	Cookie       bool   `yaml:"cookie"`
+	NSID         bool   `yaml:"nsid"`
+}
+
+type DNSSEC struct {
+	Enabled      bool     `yaml:"enabled"`
+	TrustAnchors []string `yaml:"trust_anchors"`
+}
+
+type DNSExpect struct {
+	IPs        []string `yaml:"ips"`
+	CNAMEs     []string `yaml:"cnames"`
+	MXHosts    []string `yaml:"mx_hosts"`
+	TXTRegexes []string `yaml:"txt_regexes"`
+	MinAnswers int      `yaml:"min_answers"`
+	MaxAnswers int      `yaml:"max_answers"`
+	Flags      []string `yaml:"flags"`
+}
+
+// DNSZoneTarget checks that every authoritative nameserver of a zone serves
+// the same SOA serial.
+type DNSZoneTarget struct {
+	Name    string        `yaml:"name"`
+	Zone    string        `yaml:"zone"`
+	RPS     float64       `yaml:"rps"`
+	Timeout time.Duration `yaml:"timeout"`
+	// Resolver is used to discover the NS set and nameserver addresses.
+	Resolver string `yaml:"resolver"`
+	// Port is queried on every authoritative nameserver.
+	Port int `yaml:"port"`
+}
+
+// DNSCompareTarget sends the same question to every server and compares the
+// answer sets they return.
+type DNSCompareTarget struct {
+	Name       string        `yaml:"name"`
+	Domain     string        `yaml:"domain"`
+	RecordType string        `yaml:"record_type"`
+	RPS        float64       `yaml:"rps"`
+	Timeout    time.Duration `yaml:"timeout"`
+	// Servers are host or host:port, port 53 by default.
+	Servers []string `yaml:"servers"`
+
+	// QType is RecordType resolved by validate.
+	QType uint16 `yaml:"-"`
 }
 
 type K8STarget struct {
 	Enabled     bool             `yaml:"enabled"`
 	SimpleProbe []K8SSimpleProbe `yaml:"simple-probe"`
+
+	// Kubeconfig and Context select the cluster SimpleProbe runs against. When
+	// both are empty the standard loading rules apply: $KUBECONFIG,
+	// ~/.kube/config, then the in-cluster service account.
+	Kubeconfig string `yaml:"kubeconfig"`
+	Context    string `yaml:"context"`
+	// Clusters are further API servers to probe, each under its own name.
+	Clusters []K8SCluster `yaml:"clusters"`
+}
+
+type K8SCluster struct {
+	Name        string           `yaml:"name"`
+	Kubeconfig  string           `yaml:"kubeconfig"`
+	Context     string           `yaml:"context"`
+	SimpleProbe []K8SSimpleProbe `yaml:"simple-probe"`
+}
+
+// AllClusters returns the clusters to probe, starting with the unnamed one
+// configured at the top level if it has any probes.
+func (t K8STarget) AllClusters() []K8SCluster {
+	var clusters []K8SCluster
+	if len(t.SimpleProbe) > 0 {
+		clusters = append(clusters, K8SCluster{
+			Kubeconfig:  t.Kubeconfig,
+			Context:     t.Context,
+			SimpleProbe: t.SimpleProbe,
+		})
+	}
+	return append(clusters, t.Clusters...)
 }
 
+// K8SSimpleProbe watches the pods of a namespace and measures the latency of
+// a one-item list against the API server RPS times a second.
 type K8SSimpleProbe struct {
-	NameSpace string  `yaml:"namespace"`
-	RPS       float64 `yaml:"rps"`
+	NameSpace string        `yaml:"namespace"`
+	RPS       float64       `yaml:"rps"`
+	Timeout   time.Duration `yaml:"timeout"`
 }
 
 type ICMPTarget struct {
@@ -67,6 +298,127 @@ type ICMPTarget struct {
 	TTL     int           `yaml:"ttl"`
 	RPS     float64       `yaml:"rps"`
 	Timeout time.Duration `yaml:"timeout"`
+	// Count echoes are sent Interval apart on every probe.
+	Count    int           `yaml:"count"`
+	Interval time.Duration `yaml:"interval"`
+	// Privileged uses raw sockets, which need CAP_NET_RAW, instead of ICMP
+	// datagram sockets, which need net.ipv4.ping_group_range.
+	Privileged bool `yaml:"privileged"`
+	// IPVersion is "4", "6" or "dual". By default the first resolved
+	// address is probed, whatever its family.
+	IPVersion string `yaml:"ip_version"`
+	// Size is the echo payload in bytes. DontFragment sets the DF bit so
+	// oversized echoes are dropped instead of fragmented.
+	Size         int  `yaml:"size"`
+	DontFragment bool `yaml:"dont_fragment"`
+	// PMTUDiscovery searches for the largest packet up to MaxMTU bytes that
+	// reaches the host unfragmented, once every PMTUInterval.
+	PMTUDiscovery bool          `yaml:"pmtu_discovery"`
+	MaxMTU        int           `yaml:"max_mtu"`
+	PMTUInterval  time.Duration `yaml:"pmtu_interval"`
+	// Flows spreads probing over that many ICMP identifiers, each sending a
+	// burst, so ECMP paths that hash on it are measured separately.
+	Flows int `yaml:"flows"`
+
+	SocketOptions `yaml:",inline"`
+}
+
+// TracerouteTarget probes every hop on the path to Host by sending probes
+// with increasing TTLs. It needs CAP_NET_RAW to receive the ICMP errors.
+type TracerouteTarget struct {
+	Name     string        `yaml:"name"`
+	Host     string        `yaml:"host"`
+	RPS      float64       `yaml:"rps"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Protocol string        `yaml:"protocol"`
+	// Port is the destination port of udp and tcp probes.
+	Port    int `yaml:"port"`
+	MaxHops int `yaml:"max_hops"`
+	// Count probes are sent to every hop on each run.
+	Count int `yaml:"count"`
+	// Flows additionally sends Count probes to the host over each of that
+	// many fixed flows: udp and tcp flows use source ports from SourcePort
+	// on, icmp flows an identifier each.
+	Flows      int `yaml:"flows"`
+	SourcePort int `yaml:"source_port"`
+
+	SocketOptions `yaml:",inline"`
+}
+
+// TWAMPTarget sends TWAMP-light test packets to the reflector of another
+// exporter.
+type TWAMPTarget struct {
+	Name    string        `yaml:"name"`
+	Address string        `yaml:"address"`
+	RPS     float64       `yaml:"rps"`
+	Timeout time.Duration `yaml:"timeout"`
+	// Count packets are sent Interval apart on every probe.
+	Count    int           `yaml:"count"`
+	Interval time.Duration `yaml:"interval"`
+	// ClockSynchronized claims a synchronized clock even if the kernel does
+	// not know about it, e.g. when it is disciplined by PTP.
+	ClockSynchronized bool `yaml:"clock_synchronized"`
+
+	SocketOptions `yaml:",inline"`
+}
+
+type SSHTarget struct {
+	Name               string        `yaml:"name"`
+	Host               string        `yaml:"host"`
+	Port               int           `yaml:"port"`
+	RPS                float64       `yaml:"rps"`
+	Timeout            time.Duration `yaml:"timeout"`
+	HostKeyFingerprint string        `yaml:"host_key_fingerprint"`
+	User               string        `yaml:"user"`
+	PrivateKeyFile     string        `yaml:"private_key_file"`
+
+	SocketOptions `yaml:",inline"`
+}
+
+type ExecTarget struct {
+	Name           string        `yaml:"name"`
+	Command        string        `yaml:"command"`
+	Args           []string      `yaml:"args"`
+	RPS            float64       `yaml:"rps"`
+	Timeout        time.Duration `yaml:"timeout"`
+	MaxConcurrency int           `yaml:"max_concurrency"`
+	OutputFormat   string        `yaml:"output_format"`
+}
+
+type PrometheusTarget struct {
+	Name          string        `yaml:"name"`
+	URL           string        `yaml:"url"`
+	RPS           float64       `yaml:"rps"`
+	Timeout       time.Duration `yaml:"timeout"`
+	TLSSkipVerify bool          `yaml:"tls_skip_verify"`
+	Assertions    []string      `yaml:"assertions"`
+}
+
+type ElasticsearchTarget struct {
+	Name          string        `yaml:"name"`
+	URL           string        `yaml:"url"`
+	RPS           float64       `yaml:"rps"`
+	Timeout       time.Duration `yaml:"timeout"`
+	Username      string        `yaml:"username"`
+	Password      string        `yaml:"password"`
+	APIKey        string        `yaml:"api_key"`
+	CAFile        string        `yaml:"ca_file"`
+	TLSSkipVerify bool          `yaml:"tls_skip_verify"`
+}
+
+type S3Target struct {
+	Name               string        `yaml:"name"`
+	Endpoint           string        `yaml:"endpoint"`
+	Region             string        `yaml:"region"`
+	Bucket             string        `yaml:"bucket"`
+	KeyPrefix          string        `yaml:"key_prefix"`
+	AccessKey          string        `yaml:"access_key"`
+	SecretKey          string        `yaml:"secret_key"`
+	ObjectSize         int           `yaml:"object_size"`
+	VirtualHostedStyle bool          `yaml:"virtual_hosted_style"`
+	TLSSkipVerify      bool          `yaml:"tls_skip_verify"`
+	RPS                float64       `yaml:"rps"`
+	Timeout            time.Duration `yaml:"timeout"`
 }
 
 func Load(path string) (Config, error) {
@@ -101,7 +453,7 @@ func (c *Config) setDefaults() error {
 		}
 	}
 
-	defaultServer, err := lookupDefaultDNSServer()
+	resolvConf, err := loadResolvConf()
 	if err != nil {
 		return err
 	}
@@ -113,20 +465,74 @@ func (c *Config) setDefaults() error {
 		if c.Targets.DNS[i].RecordType == "" {
 			c.Targets.DNS[i].RecordType = "A"
 		}
-		if c.Targets.DNS[i].ServerIP == "" {
-			c.Targets.DNS[i].ServerIP = defaultServer
+		c.Targets.DNS[i].RecordType = strings.ToUpper(c.Targets.DNS[i].RecordType)
+		if c.Targets.DNS[i].ServerIP == "" && len(c.Targets.DNS[i].Servers) == 0 {
+			c.Targets.DNS[i].ServerIP = resolvConf.Servers[0]
 		}
+		if c.Targets.DNS[i].Transport == "" {
+			c.Targets.DNS[i].Transport = DNSTransportUDP
+		}
+		c.Targets.DNS[i].Transport = strings.ToLower(c.Targets.DNS[i].Transport)
 		if c.Targets.DNS[i].ServerPort == 0 {
-			c.Targets.DNS[i].ServerPort = 53
+			c.Targets.DNS[i].ServerPort = defaultDNSPort(c.Targets.DNS[i].Transport)
+		}
+		if len(c.Targets.DNS[i].Servers) == 0 {
+			if c.Targets.DNS[i].ServerIP == "all" {
+				c.Targets.DNS[i].Servers = append([]string(nil), resolvConf.Servers...)
+			} else {
+				c.Targets.DNS[i].Servers = []string{c.Targets.DNS[i].ServerIP}
+			}
+		}
+		for j, server := range c.Targets.DNS[i].Servers {
+			c.Targets.DNS[i].Servers[j] = withDefaultPort(server, c.Targets.DNS[i].ServerPort)
+		}
+		if c.Targets.DNS[i].Search {
+			if c.Targets.DNS[i].SearchDomains == nil {
+				c.Targets.DNS[i].SearchDomains = resolvConf.Search
+			}
+			if c.Targets.DNS[i].NDots <= 0 {
+				c.Targets.DNS[i].NDots = resolvConf.Ndots
+			}
+		}
+		if c.Targets.DNS[i].DoHPath == "" {
+			c.Targets.DNS[i].DoHPath = "/dns-query"
+		}
+		if c.Targets.DNS[i].DNSSEC.Enabled && len(c.Targets.DNS[i].DNSSEC.TrustAnchors) == 0 {
+			c.Targets.DNS[i].DNSSEC.TrustAnchors = []string{defaultDNSSECTrustAnchor}
+		}
+	}
+
+	for i := range c.Targets.DNSZones {
+		if c.Targets.DNSZones[i].Timeout <= 0 {
+			c.Targets.DNSZones[i].Timeout = defaultDNSTimeout
+		}
+		if c.Targets.DNSZones[i].Resolver == "" {
+			c.Targets.DNSZones[i].Resolver = resolvConf.Servers[0]
+		}
+		c.Targets.DNSZones[i].Resolver = withDefaultPort(c.Targets.DNSZones[i].Resolver, 53)
+		if c.Targets.DNSZones[i].Port == 0 {
+			c.Targets.DNSZones[i].Port = 53
 		}
 	}
 
-	for i := range c.Targets.K8S.SimpleProbe {
-		if c.Targets.K8S.SimpleProbe[i].RPS <= 0 {
-			c.Targets.K8S.SimpleProbe[i].RPS = defaultK8sRPS
+	for i := range c.Targets.DNSCompare {
+		if c.Targets.DNSCompare[i].Timeout <= 0 {
+			c.Targets.DNSCompare[i].Timeout = defaultDNSTimeout
+		}
+		if c.Targets.DNSCompare[i].RecordType == "" {
+			c.Targets.DNSCompare[i].RecordType = "A"
+		}
+		c.Targets.DNSCompare[i].RecordType = strings.ToUpper(c.Targets.DNSCompare[i].RecordType)
+		for j, server := range c.Targets.DNSCompare[i].Servers {
+			c.Targets.DNSCompare[i].Servers[j] = withDefaultPort(server, 53)
 		}
 	}
 
+	setK8SDefaults(c.Targets.K8S.SimpleProbe)
+	for i := range c.Targets.K8S.Clusters {
+		setK8SDefaults(c.Targets.K8S.Clusters[i].SimpleProbe)
+	}
+
 	for i := range c.Targets.ICMP {
 		if c.Targets.ICMP[i].Timeout <= 0 {
 			c.Targets.ICMP[i].Timeout = defaultICMPTimeout
@@ -134,16 +540,123 @@ func (c *Config) setDefaults() error {
 		if c.Targets.ICMP[i].TTL <= 0 {
 			c.Targets.ICMP[i].TTL = 64
 		}
+		if c.Targets.ICMP[i].Count <= 0 {
+			c.Targets.ICMP[i].Count = 1
+		}
+		if c.Targets.ICMP[i].Interval <= 0 {
+			c.Targets.ICMP[i].Interval = defaultICMPInterval
+		}
+		if c.Targets.ICMP[i].Size <= 0 {
+			c.Targets.ICMP[i].Size = defaultICMPSize
+		}
+		if c.Targets.ICMP[i].MaxMTU <= 0 {
+			c.Targets.ICMP[i].MaxMTU = defaultICMPMaxMTU
+		}
+		if c.Targets.ICMP[i].PMTUInterval <= 0 {
+			c.Targets.ICMP[i].PMTUInterval = defaultICMPPMTUInterval
+		}
+	}
+
+	for i := range c.Targets.Traceroute {
+		if c.Targets.Traceroute[i].Timeout <= 0 {
+			c.Targets.Traceroute[i].Timeout = defaultICMPTimeout
+		}
+		if c.Targets.Traceroute[i].Protocol == "" {
+			c.Targets.Traceroute[i].Protocol = TracerouteProtocolICMP
+		}
+		if c.Targets.Traceroute[i].Port <= 0 {
+			switch c.Targets.Traceroute[i].Protocol {
+			case TracerouteProtocolUDP:
+				c.Targets.Traceroute[i].Port = 33434
+			case TracerouteProtocolTCP:
+				c.Targets.Traceroute[i].Port = 80
+			}
+		}
+		if c.Targets.Traceroute[i].MaxHops <= 0 {
+			c.Targets.Traceroute[i].MaxHops = 30
+		}
+		if c.Targets.Traceroute[i].Count <= 0 {
+			c.Targets.Traceroute[i].Count = 3
+		}
+	}
+	setTracerouteSourcePorts(c.Targets.Traceroute)
+
+	for i := range c.Targets.TWAMP {
+		c.Targets.TWAMP[i].Address = withDefaultPort(c.Targets.TWAMP[i].Address, defaultTWAMPPort)
+		if c.Targets.TWAMP[i].Timeout <= 0 {
+			c.Targets.TWAMP[i].Timeout = defaultICMPTimeout
+		}
+		if c.Targets.TWAMP[i].Count <= 0 {
+			c.Targets.TWAMP[i].Count = 10
+		}
+		if c.Targets.TWAMP[i].Interval <= 0 {
+			c.Targets.TWAMP[i].Interval = defaultTWAMPInterval
+		}
+	}
+
+	for i := range c.Targets.SSH {
+		if c.Targets.SSH[i].Timeout <= 0 {
+			c.Targets.SSH[i].Timeout = defaultSSHTimeout
+		}
+		if c.Targets.SSH[i].Port == 0 {
+			c.Targets.SSH[i].Port = 22
+		}
+	}
+
+	for i := range c.Targets.Exec {
+		if c.Targets.Exec[i].Timeout <= 0 {
+			c.Targets.Exec[i].Timeout = defaultExecTimeout
+		}
+		if c.Targets.Exec[i].MaxConcurrency <= 0 {
+			c.Targets.Exec[i].MaxConcurrency = 1
+		}
+	}
+
+	for i := range c.Targets.Prometheus {
+		if c.Targets.Prometheus[i].Timeout <= 0 {
+			c.Targets.Prometheus[i].Timeout = defaultPromTimeout
+		}
+	}
+
+	for i := range c.Targets.Elasticsearch {
+		if c.Targets.Elasticsearch[i].Timeout <= 0 {
+			c.Targets.Elasticsearch[i].Timeout = defaultESTimeout
+		}
+	}
+
+	for i := range c.Targets.S3 {
+		if c.Targets.S3[i].Timeout <= 0 {
+			c.Targets.S3[i].Timeout = defaultS3Timeout
+		}
+		if c.Targets.S3[i].Region == "" {
+			c.Targets.S3[i].Region = "us-east-1"
+		}
+		if c.Targets.S3[i].KeyPrefix == "" {
+			c.Targets.S3[i].KeyPrefix = "health-exporter/"
+		}
+		if c.Targets.S3[i].ObjectSize <= 0 {
+			c.Targets.S3[i].ObjectSize = 1024
+		}
 	}
 
 	return nil
 }
 
-func (c Config) validate() error {
+func (c *Config) validate() error {
 	if len(c.Targets.HTTP) == 0 &&
 		len(c.Targets.DNS) == 0 &&
 		len(c.Targets.ICMP) == 0 &&
-		(!c.Targets.K8S.Enabled || len(c.Targets.K8S.SimpleProbe) == 0) {
+		len(c.Targets.SSH) == 0 &&
+		len(c.Targets.Exec) == 0 &&
+		len(c.Targets.Prometheus) == 0 &&
+		len(c.Targets.Elasticsearch) == 0 &&
+		len(c.Targets.S3) == 0 &&
+		len(c.Targets.DNSZones) == 0 &&
+		len(c.Targets.DNSCompare) == 0 &&
+		len(c.Targets.Traceroute) == 0 &&
+		len(c.Targets.TWAMP) == 0 &&
+		c.Reflector.Listen == "" &&
+		(!c.Targets.K8S.Enabled || len(c.Targets.K8S.AllClusters()) == 0) {
 		return errors.New("no probes configured")
 	}
 
@@ -157,27 +670,144 @@ func (c Config) validate() error {
 		if h.RPS <= 0 {
 			return fmt.Errorf("http target %q: rps should be > 0", h.Name)
 		}
+		if err := h.SocketOptions.validate(); err != nil {
+			return fmt.Errorf("http target %q: %w", h.Name, err)
+		}
 	}
 
-	for _, d := range c.Targets.DNS {
+	for i, d := range c.Targets.DNS {
 		if d.Name == "" {
 			return errors.New("dns target name is required")
 		}
-		if d.Domain == "" {
-			return fmt.Errorf("dns target %q: domain is required", d.Name)
+		if d.Domain == "" && d.DomainsFile == "" {
+			return fmt.Errorf("dns target %q: domain or domains_file is required", d.Name)
 		}
+		if d.Domain != "" && d.DomainsFile != "" {
+			return fmt.Errorf("dns target %q: domain and domains_file are mutually exclusive", d.Name)
+		}
+		qtype, err := parseRecordType(d.RecordType)
+		if err != nil {
+			return fmt.Errorf("dns target %q: %w", d.Name, err)
+		}
+		c.Targets.DNS[i].QType = qtype
 		if d.RPS <= 0 {
 			return fmt.Errorf("dns target %q: rps should be > 0", d.Name)
 		}
+		if len(d.Servers) == 0 {
+			return fmt.Errorf("dns target %q: no servers configured", d.Name)
+		}
+		seenServers := make(map[string]struct{}, len(d.Servers))
+		for _, server := range d.Servers {
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				return fmt.Errorf("dns target %q: server %q: %w", d.Name, server, err)
+			}
+			if _, ok := seenServers[server]; ok {
+				return fmt.Errorf("dns target %q: duplicate server %q", d.Name, server)
+			}
+			seenServers[server] = struct{}{}
+		}
+		switch d.Transport {
+		case DNSTransportUDP, DNSTransportTCP, DNSTransportDoT, DNSTransportDoH, DNSTransportDoQ:
+		default:
+			return fmt.Errorf("dns target %q: unknown transport %q", d.Name, d.Transport)
+		}
+		if err := d.Expect.validate(); err != nil {
+			return fmt.Errorf("dns target %q: expect: %w", d.Name, err)
+		}
+		if d.EDNS.UDPSize != 0 && d.EDNS.UDPSize < dns.MinMsgSize {
+			return fmt.Errorf("dns target %q: edns udp_size should be >= %d", d.Name, dns.MinMsgSize)
+		}
+		if d.EDNS.ClientSubnet != "" {
+			if _, err := ParseIPOrPrefix(d.EDNS.ClientSubnet); err != nil {
+				return fmt.Errorf("dns target %q: edns client_subnet: %w", d.Name, err)
+			}
+		}
+		for _, anchor := range d.DNSSEC.TrustAnchors {
+			rr, err := dns.NewRR(anchor)
+			if err != nil {
+				return fmt.Errorf("dns target %q: trust anchor %q: %w", d.Name, anchor, err)
+			}
+			switch rr.(type) {
+			case *dns.DS, *dns.DNSKEY:
+			default:
+				return fmt.Errorf("dns target %q: trust anchor %q should be a DS or DNSKEY record", d.Name, anchor)
+			}
+		}
+	}
+
+	for _, z := range c.Targets.DNSZones {
+		if z.Name == "" {
+			return errors.New("dns zone target name is required")
+		}
+		if z.Zone == "" {
+			return fmt.Errorf("dns zone target %q: zone is required", z.Name)
+		}
+		if _, ok := dns.IsDomainName(z.Zone); !ok {
+			return fmt.Errorf("dns zone target %q: invalid zone %q", z.Name, z.Zone)
+		}
+		if z.RPS <= 0 {
+			return fmt.Errorf("dns zone target %q: rps should be > 0", z.Name)
+		}
+		if _, _, err := net.SplitHostPort(z.Resolver); err != nil {
+			return fmt.Errorf("dns zone target %q: resolver %q: %w", z.Name, z.Resolver, err)
+		}
+		if z.Port <= 0 || z.Port > 65535 {
+			return fmt.Errorf("dns zone target %q: port should be between 1 and 65535", z.Name)
+		}
+	}
+
+	for i, d := range c.Targets.DNSCompare {
+		if d.Name == "" {
+			return errors.New("dns compare target name is required")
+		}
+		if d.Domain == "" {
+			return fmt.Errorf("dns compare target %q: domain is required", d.Name)
+		}
+		qtype, err := parseRecordType(d.RecordType)
+		if err != nil {
+			return fmt.Errorf("dns compare target %q: %w", d.Name, err)
+		}
+		c.Targets.DNSCompare[i].QType = qtype
+		if d.RPS <= 0 {
+			return fmt.Errorf("dns compare target %q: rps should be > 0", d.Name)
+		}
+		if len(d.Servers) < 2 {
+			return fmt.Errorf("dns compare target %q: at least two servers are required", d.Name)
+		}
+		seenServers := make(map[string]struct{}, len(d.Servers))
+		for _, server := range d.Servers {
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				return fmt.Errorf("dns compare target %q: server %q: %w", d.Name, server, err)
+			}
+			if _, ok := seenServers[server]; ok {
+				return fmt.Errorf("dns compare target %q: duplicate server %q", d.Name, server)
+			}
+			seenServers[server] = struct{}{}
+		}
 	}
 
 	if c.Targets.K8S.Enabled {
-		if len(c.Targets.K8S.SimpleProbe) == 0 {
+		if len(c.Targets.K8S.AllClusters()) == 0 {
 			return errors.New("k8s probes enabled but no namespace configured")
 		}
-		for _, sp := range c.Targets.K8S.SimpleProbe {
-			if sp.NameSpace == "" {
-				return errors.New("k8s simple probe namespace is required")
+		seenClusters := map[string]struct{}{}
+		for _, cluster := range c.Targets.K8S.Clusters {
+			if cluster.Name == "" {
+				return errors.New("k8s cluster name is required")
+			}
+			if _, ok := seenClusters[cluster.Name]; ok {
+				return fmt.Errorf("k8s cluster %q: duplicate name", cluster.Name)
+			}
+			seenClusters[cluster.Name] = struct{}{}
+			if len(cluster.SimpleProbe) == 0 {
+				return fmt.Errorf("k8s cluster %q: no namespace configured", cluster.Name)
+			}
+		}
+		for _, cluster := range c.Targets.K8S.AllClusters() {
+			for _, sp := range cluster.SimpleProbe {
+				if sp.NameSpace == "" {
+					return errors.New("k8s simple probe namespace is required")
+				}
 			}
 		}
 	}
@@ -192,18 +822,303 @@ func (c Config) validate() error {
 		if icmp.RPS <= 0 {
 			return fmt.Errorf("icmp target %q: rps should be > 0", icmp.Name)
 		}
+		switch icmp.IPVersion {
+		case "", ICMPIPVersion4, ICMPIPVersion6, ICMPIPVersionDual:
+		default:
+			return fmt.Errorf("icmp target %q: ip_version should be 4, 6 or dual", icmp.Name)
+		}
+		if icmp.Size > 65507 {
+			return fmt.Errorf("icmp target %q: size should be <= 65507", icmp.Name)
+		}
+		if icmp.PMTUDiscovery && (icmp.MaxMTU < 68 || icmp.MaxMTU > 65535) {
+			return fmt.Errorf("icmp target %q: max_mtu should be between 68 and 65535", icmp.Name)
+		}
+		if icmp.Flows < 0 || icmp.Flows > maxFlows {
+			return fmt.Errorf("icmp target %q: flows should be between 0 and %d", icmp.Name, maxFlows)
+		}
+		if err := icmp.SocketOptions.validate(); err != nil {
+			return fmt.Errorf("icmp target %q: %w", icmp.Name, err)
+		}
+	}
+
+	for _, t := range c.Targets.Traceroute {
+		if t.Name == "" {
+			return errors.New("traceroute target name is required")
+		}
+		if t.Host == "" {
+			return fmt.Errorf("traceroute target %q: host is required", t.Name)
+		}
+		if t.RPS <= 0 {
+			return fmt.Errorf("traceroute target %q: rps should be > 0", t.Name)
+		}
+		switch t.Protocol {
+		case TracerouteProtocolICMP, TracerouteProtocolUDP, TracerouteProtocolTCP:
+		default:
+			return fmt.Errorf("traceroute target %q: protocol should be icmp, udp or tcp", t.Name)
+		}
+		if t.Port > 65535 {
+			return fmt.Errorf("traceroute target %q: port should be <= 65535", t.Name)
+		}
+		if t.MaxHops > 255 {
+			return fmt.Errorf("traceroute target %q: max_hops should be <= 255", t.Name)
+		}
+		if t.Flows < 0 || t.Flows > maxFlows {
+			return fmt.Errorf("traceroute target %q: flows should be between 0 and %d", t.Name, maxFlows)
+		}
+		if t.SourcePort > 65535 || t.SourcePort+t.Flows > 65536 {
+			return fmt.Errorf("traceroute target %q: source_port %d leaves no room for %d flows", t.Name, t.SourcePort, t.Flows)
+		}
+		if err := t.SocketOptions.validate(); err != nil {
+			return fmt.Errorf("traceroute target %q: %w", t.Name, err)
+		}
+	}
+	for i, t := range c.Targets.Traceroute {
+		for _, other := range c.Targets.Traceroute[:i] {
+			if sourcePortsOverlap(t, other) {
+				return fmt.Errorf("traceroute target %q: source ports %d-%d overlap those of %q", t.Name, t.SourcePort, t.SourcePort+t.Flows-1, other.Name)
+			}
+		}
+	}
+
+	for _, t := range c.Targets.TWAMP {
+		if t.Name == "" {
+			return errors.New("twamp target name is required")
+		}
+		if t.Address == "" {
+			return fmt.Errorf("twamp target %q: address is required", t.Name)
+		}
+		if t.RPS <= 0 {
+			return fmt.Errorf("twamp target %q: rps should be > 0", t.Name)
+		}
+		if err := t.SocketOptions.validate(); err != nil {
+			return fmt.Errorf("twamp target %q: %w", t.Name, err)
+		}
+	}
+
+	if c.Reflector.Listen != "" && len(c.Reflector.Allow) == 0 {
+		return errors.New("reflector: allow is required, an open reflector would answer anyone")
+	}
+	for _, ip := range c.Reflector.Allow {
+		if _, err := ParseIPOrPrefix(ip); err != nil {
+			return fmt.Errorf("reflector: %w", err)
+		}
+	}
+
+	for _, s := range c.Targets.SSH {
+		if s.Name == "" {
+			return errors.New("ssh target name is required")
+		}
+		if s.Host == "" {
+			return fmt.Errorf("ssh target %q: host is required", s.Name)
+		}
+		if s.RPS <= 0 {
+			return fmt.Errorf("ssh target %q: rps should be > 0", s.Name)
+		}
+		if s.PrivateKeyFile != "" && s.User == "" {
+			return fmt.Errorf("ssh target %q: user is required when private_key_file is set", s.Name)
+		}
+		if err := s.SocketOptions.validate(); err != nil {
+			return fmt.Errorf("ssh target %q: %w", s.Name, err)
+		}
+	}
+
+	for _, e := range c.Targets.Exec {
+		if e.Name == "" {
+			return errors.New("exec target name is required")
+		}
+		if e.Command == "" {
+			return fmt.Errorf("exec target %q: command is required", e.Name)
+		}
+		if e.RPS <= 0 {
+			return fmt.Errorf("exec target %q: rps should be > 0", e.Name)
+		}
+		switch e.OutputFormat {
+		case "", "prometheus", "json":
+		default:
+			return fmt.Errorf("exec target %q: unknown output_format %q", e.Name, e.OutputFormat)
+		}
+	}
+
+	for _, p := range c.Targets.Prometheus {
+		if p.Name == "" {
+			return errors.New("prometheus target name is required")
+		}
+		if p.URL == "" {
+			return fmt.Errorf("prometheus target %q: url is required", p.Name)
+		}
+		if p.RPS <= 0 {
+			return fmt.Errorf("prometheus target %q: rps should be > 0", p.Name)
+		}
+		for _, expr := range p.Assertions {
+			if _, err := exposition.ParseAssertion(expr); err != nil {
+				return fmt.Errorf("prometheus target %q: %w", p.Name, err)
+			}
+		}
+	}
+
+	for _, es := range c.Targets.Elasticsearch {
+		if es.Name == "" {
+			return errors.New("elasticsearch target name is required")
+		}
+		if es.URL == "" {
+			return fmt.Errorf("elasticsearch target %q: url is required", es.Name)
+		}
+		if es.RPS <= 0 {
+			return fmt.Errorf("elasticsearch target %q: rps should be > 0", es.Name)
+		}
+		if es.APIKey != "" && es.Username != "" {
+			return fmt.Errorf("elasticsearch target %q: api_key and username are mutually exclusive", es.Name)
+		}
+	}
+
+	for _, s3 := range c.Targets.S3 {
+		if s3.Name == "" {
+			return errors.New("s3 target name is required")
+		}
+		if s3.Endpoint == "" {
+			return fmt.Errorf("s3 target %q: endpoint is required", s3.Name)
+		}
+		if s3.Bucket == "" {
+			return fmt.Errorf("s3 target %q: bucket is required", s3.Name)
+		}
+		if s3.AccessKey == "" || s3.SecretKey == "" {
+			return fmt.Errorf("s3 target %q: access_key and secret_key are required", s3.Name)
+		}
+		if s3.RPS <= 0 {
+			return fmt.Errorf("s3 target %q: rps should be > 0", s3.Name)
+		}
+	}
+
+	return nil
+}
+
+// setTracerouteSourcePorts gives every target without a source_port the first
+// ports from defaultTracerouteSourcePort on that no other target binds.
+func setTracerouteSourcePorts(targets []TracerouteTarget) {
+	explicit := make([]bool, len(targets))
+	for i := range targets {
+		explicit[i] = targets[i].SourcePort > 0
+	}
+	for i := range targets {
+		if explicit[i] {
+			continue
+		}
+		targets[i].SourcePort = defaultTracerouteSourcePort
+		for moved := true; moved; {
+			moved = false
+			for j := range targets {
+				if j != i && (explicit[j] || j < i) && sourcePortsOverlap(targets[i], targets[j]) {
+					targets[i].SourcePort = targets[j].SourcePort + targets[j].Flows
+					moved = true
+				}
+			}
+		}
+	}
+}
+
+// sourcePortsOverlap reports whether the flows of a and b bind a common
+// source port, which would fail the later of them.
+func sourcePortsOverlap(a, b TracerouteTarget) bool {
+	if a.Flows == 0 || b.Flows == 0 || a.Protocol == TracerouteProtocolICMP || a.Protocol != b.Protocol {
+		return false
+	}
+	return a.SourcePort < b.SourcePort+b.Flows && b.SourcePort < a.SourcePort+a.Flows
+}
+
+func setK8SDefaults(probes []K8SSimpleProbe) {
+	for i := range probes {
+		if probes[i].RPS <= 0 {
+			probes[i].RPS = defaultK8sRPS
+		}
+		if probes[i].Timeout <= 0 {
+			probes[i].Timeout = defaultK8sTimeout
+		}
 	}
+}
+
+func defaultDNSPort(transport string) int {
+	switch transport {
+	case DNSTransportDoT, DNSTransportDoQ:
+		return 853
+	case DNSTransportDoH:
+		return 443
+	default:
+		return 53
+	}
+}
 
+func (e DNSExpect) validate() error {
+	for _, ip := range e.IPs {
+		if _, err := ParseIPOrPrefix(ip); err != nil {
+			return err
+		}
+	}
+	for _, expr := range e.TXTRegexes {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("txt regex %q: %w", expr, err)
+		}
+	}
+	if e.MinAnswers < 0 || e.MaxAnswers < 0 {
+		return errors.New("answer counts should be >= 0")
+	}
+	if e.MaxAnswers > 0 && e.MinAnswers > e.MaxAnswers {
+		return fmt.Errorf("min_answers %d is greater than max_answers %d", e.MinAnswers, e.MaxAnswers)
+	}
+	for _, flag := range e.Flags {
+		switch strings.ToLower(flag) {
+		case "aa", "ad", "ra":
+		default:
+			return fmt.Errorf("unknown flag %q", flag)
+		}
+	}
 	return nil
 }
 
-func lookupDefaultDNSServer() (string, error) {
+// ParseIPOrPrefix accepts either a single address or a CIDR and returns it as
+// a prefix.
+func ParseIPOrPrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid cidr %q: %w", s, err)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid ip %q: %w", s, err)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func parseRecordType(s string) (uint16, error) {
+	qtype, ok := dns.StringToType[s]
+	if !ok || qtype == dns.TypeNone {
+		return 0, fmt.Errorf("unknown record type %q", s)
+	}
+	return qtype, nil
+}
+
+func loadResolvConf() (*dns.ClientConfig, error) {
 	cfg, err := dns.ClientConfigFromFile("/etc/resolv.conf")
 	if err != nil {
-		return "", fmt.Errorf("load resolv.conf: %w", err)
+		return nil, fmt.Errorf("load resolv.conf: %w", err)
 	}
 	if len(cfg.Servers) == 0 {
-		return "", errors.New("no dns servers found in resolv.conf")
+		return nil, errors.New("no dns servers found in resolv.conf")
+	}
+	return cfg, nil
+}
+
+// withDefaultPort appends port to server unless it already carries one.
+// Bare IPv6 addresses are accepted with or without brackets.
+func withDefaultPort(server string, port int) string {
+	if addr, err := netip.ParseAddr(strings.Trim(server, "[]")); err == nil {
+		return net.JoinHostPort(addr.String(), strconv.Itoa(port))
+	}
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
 	}
-	return cfg.Servers[0], nil
+	return net.JoinHostPort(server, strconv.Itoa(port))
 }
@@ -0,0 +1,175 @@
+package exposition
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Assertion is a threshold check of the form
+// name{label="value",...} <op> <number>, e.g. queue_depth{queue="orders"} < 1000.
+type Assertion struct {
+	Expr      string
+	Name      string
+	Matchers  map[string]string
+	Operator  string
+	Threshold float64
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+func ParseAssertion(expr string) (Assertion, error) {
+	a := Assertion{Expr: expr, Matchers: map[string]string{}}
+	rest := strings.TrimSpace(expr)
+
+	i := 0
+	for i < len(rest) && isNameChar(rest[i]) {
+		i++
+	}
+	if i == 0 {
+		return Assertion{}, fmt.Errorf("assertion %q: metric name expected", expr)
+	}
+	a.Name = rest[:i]
+	rest = strings.TrimSpace(rest[i:])
+
+	if strings.HasPrefix(rest, "{") {
+		end, err := parseMatchers(rest, a.Matchers)
+		if err != nil {
+			return Assertion{}, fmt.Errorf("assertion %q: %w", expr, err)
+		}
+		rest = strings.TrimSpace(rest[end:])
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op) {
+			a.Operator = op
+			rest = strings.TrimSpace(rest[len(op):])
+			break
+		}
+	}
+	if a.Operator == "" {
+		return Assertion{}, fmt.Errorf("assertion %q: comparison operator expected", expr)
+	}
+
+	threshold, err := strconv.ParseFloat(rest, 64)
+	if err != nil {
+		return Assertion{}, fmt.Errorf("assertion %q: invalid threshold %q", expr, rest)
+	}
+	a.Threshold = threshold
+
+	return a, nil
+}
+
+// parseMatchers reads {k="v",...} from the start of s into out and returns the
+// index just past the closing brace.
+func parseMatchers(s string, out map[string]string) (int, error) {
+	i := 1
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',') {
+			i++
+		}
+		if i >= len(s) {
+			return 0, errors.New("unterminated label matchers")
+		}
+		if s[i] == '}' {
+			return i + 1, nil
+		}
+
+		start := i
+		for i < len(s) && isNameChar(s[i]) {
+			i++
+		}
+		name := s[start:i]
+		if name == "" || i >= len(s) || s[i] != '=' {
+			return 0, fmt.Errorf("invalid label matcher at %q", s[start:])
+		}
+		i++
+
+		if i >= len(s) || s[i] != '"' {
+			return 0, fmt.Errorf("label %q: quoted value expected", name)
+		}
+		end := i + 1
+		for end < len(s) && s[end] != '"' {
+			if s[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(s) {
+			return 0, fmt.Errorf("label %q: unterminated value", name)
+		}
+		value, err := strconv.Unquote(s[i : end+1])
+		if err != nil {
+			return 0, fmt.Errorf("label %q: %w", name, err)
+		}
+		out[name] = value
+		i = end + 1
+	}
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c == ':' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// Evaluate checks every sample matching the selector. It fails when no
+// sample matches, so a disappeared series is not mistaken for a healthy one.
+func (a Assertion) Evaluate(samples []Sample) (bool, error) {
+	matched := 0
+	for _, s := range samples {
+		if !a.matches(s) {
+			continue
+		}
+		matched++
+		if !compare(s.Value, a.Operator, a.Threshold) {
+			return false, fmt.Errorf("%s is %g", s.Key(), s.Value)
+		}
+	}
+	if matched == 0 {
+		return false, fmt.Errorf("no series matched %s", a.Name)
+	}
+	return true, nil
+}
+
+func (a Assertion) matches(s Sample) bool {
+	if s.Name != a.Name {
+		return false
+	}
+	for k, v := range a.Matchers {
+		if s.Labels[k] != v && !sameBound(k, s.Labels[k], v) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameBound compares le and quantile labels as numbers, so le="1" matches a
+// bucket exposed as le="1.0" by OpenMetrics targets.
+func sameBound(label, a, b string) bool {
+	if label != "le" && label != "quantile" {
+		return false
+	}
+	x, errX := strconv.ParseFloat(a, 64)
+	y, errY := strconv.ParseFloat(b, 64)
+	return errX == nil && errY == nil && x == y
+}
+
+func compare(value float64, op string, threshold float64) bool {
+	switch op {
+	case "==":
+		return value == threshold
+	case "!=":
+		return value != threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	default:
+		return false
+	}
+}
@@ -0,0 +1,175 @@
+package exposition
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Key renders the sample as name{label="value",...} with labels sorted.
+func (s Sample) Key() string {
+	if len(s.Labels) == 0 {
+		return s.Name
+	}
+	labels := make([]string, 0, len(s.Labels))
+	for k, v := range s.Labels {
+		labels = append(labels, fmt.Sprintf("%s=%q", k, v))
+	}
+	sort.Strings(labels)
+	return s.Name + "{" + strings.Join(labels, ",") + "}"
+}
+
+// Parse reads the Prometheus text format. OpenMetrics input is accepted by
+// dropping the constructs the text parser does not know about (EOF and UNIT
+// lines, exemplars, and the extra metric types). Sample timestamps are dropped
+// too, as OpenMetrics writes them as fractional seconds where the text format
+// expects integer milliseconds, and assertions only look at values.
+func Parse(r io.Reader) ([]Sample, error) {
+	normalized, err := normalizeOpenMetrics(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(normalized)
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []Sample
+	for name, family := range families {
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string, len(m.GetLabel()))
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+
+			switch {
+			case m.Gauge != nil:
+				samples = append(samples, Sample{Name: name, Labels: labels, Value: m.GetGauge().GetValue()})
+			case m.Counter != nil:
+				samples = append(samples, Sample{Name: name, Labels: labels, Value: m.GetCounter().GetValue()})
+			case m.Untyped != nil:
+				samples = append(samples, Sample{Name: name, Labels: labels, Value: m.GetUntyped().GetValue()})
+			case m.Summary != nil:
+				for _, q := range m.GetSummary().GetQuantile() {
+					samples = append(samples, Sample{Name: name, Labels: withLabel(labels, "quantile", q.GetQuantile()), Value: q.GetValue()})
+				}
+				samples = append(samples,
+					Sample{Name: name + "_sum", Labels: labels, Value: m.GetSummary().GetSampleSum()},
+					Sample{Name: name + "_count", Labels: labels, Value: float64(m.GetSummary().GetSampleCount())},
+				)
+			case m.Histogram != nil:
+				hasInf := false
+				for _, b := range m.GetHistogram().GetBucket() {
+					hasInf = hasInf || math.IsInf(b.GetUpperBound(), 1)
+					samples = append(samples, Sample{Name: name + "_bucket", Labels: withLabel(labels, "le", b.GetUpperBound()), Value: float64(b.GetCumulativeCount())})
+				}
+				// The text parser drops the +Inf bucket, which always equals
+				// the count.
+				if !hasInf {
+					samples = append(samples, Sample{Name: name + "_bucket", Labels: withLabel(labels, "le", math.Inf(1)), Value: float64(m.GetHistogram().GetSampleCount())})
+				}
+				samples = append(samples,
+					Sample{Name: name + "_sum", Labels: labels, Value: m.GetHistogram().GetSampleSum()},
+					Sample{Name: name + "_count", Labels: labels, Value: float64(m.GetHistogram().GetSampleCount())},
+				)
+			}
+		}
+	}
+	return samples, nil
+}
+
+// withLabel returns a copy of labels with a bucket bound or quantile added,
+// formatted the way Prometheus exposes them.
+func withLabel(labels map[string]string, name string, value float64) map[string]string {
+	out := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		out[k] = v
+	}
+	out[name] = formatBound(value)
+	return out
+}
+
+func formatBound(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func normalizeOpenMetrics(r io.Reader) (io.Reader, error) {
+	var b strings.Builder
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "# EOF", strings.HasPrefix(line, "# UNIT "):
+			continue
+		case strings.HasPrefix(line, "# TYPE "):
+			fields := strings.Fields(line)
+			if len(fields) == 4 {
+				switch fields[3] {
+				case "counter", "gauge", "histogram", "summary", "untyped":
+				default:
+					line = strings.Join(fields[:3], " ") + " untyped"
+				}
+			}
+		case !strings.HasPrefix(line, "#"):
+			if i := strings.Index(line, " # {"); i >= 0 {
+				line = line[:i]
+			}
+			line = stripTimestamp(line)
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return strings.NewReader(b.String()), nil
+}
+
+// stripTimestamp removes the timestamp from a sample line, if it has one. The
+// series may have label values containing spaces, so the value and timestamp
+// are only looked for after its closing brace.
+func stripTimestamp(line string) string {
+	end := strings.IndexAny(line, "{ ")
+	if end >= 0 && line[end] == '{' {
+		inQuotes := false
+		for end++; end < len(line); end++ {
+			c := line[end]
+			if inQuotes && c == '\\' {
+				end++
+				continue
+			}
+			if c == '"' {
+				inQuotes = !inQuotes
+			} else if c == '}' && !inQuotes {
+				end++
+				break
+			}
+		}
+	}
+	if end < 0 || end > len(line) {
+		return line
+	}
+	fields := strings.Fields(line[end:])
+	if len(fields) != 2 {
+		return line
+	}
+	return line[:end] + " " + fields[0]
+}
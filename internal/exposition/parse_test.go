@@ -0,0 +1,87 @@
+package exposition
+
+import (
+	"strings"
+	"testing"
+)
+
+func parseKeys(t *testing.T, in string) map[string]float64 {
+	t.Helper()
+	samples, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	got := make(map[string]float64, len(samples))
+	for _, s := range samples {
+		got[s.Key()] = s.Value
+	}
+	return got
+}
+
+func TestParseTimestamps(t *testing.T) {
+	got := parseKeys(t, `# TYPE foo counter
+foo_total 17 1520879607.789
+bar{path="/a b}",quote="\"}"} 3 1520879607
+baz 4
+qux{a="1"} 5 # {trace_id="x"} 1 1520879607.5
+# EOF
+`)
+	want := map[string]float64{
+		"foo_total":                     17,
+		`bar{path="/a b}",quote="\"}"}`: 3,
+		"baz":                           4,
+		`qux{a="1"}`:                    5,
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v (got %v)", key, got[key], value, got)
+		}
+	}
+}
+
+func TestParseHistogramAndSummary(t *testing.T) {
+	in := `# TYPE rpc_seconds histogram
+rpc_seconds_bucket{method="get",le="0.1"} 3
+rpc_seconds_bucket{method="get",le="1.0"} 5
+rpc_seconds_bucket{method="get",le="+Inf"} 6
+rpc_seconds_sum{method="get"} 4.2
+rpc_seconds_count{method="get"} 6
+# TYPE gc_seconds summary
+gc_seconds{quantile="0.5"} 0.01
+gc_seconds{quantile="0.99"} 0.2
+gc_seconds_sum 1.5
+gc_seconds_count 30
+`
+	got := parseKeys(t, in)
+	want := map[string]float64{
+		`rpc_seconds_bucket{le="0.1",method="get"}`:  3,
+		`rpc_seconds_bucket{le="1",method="get"}`:    5,
+		`rpc_seconds_bucket{le="+Inf",method="get"}`: 6,
+		`rpc_seconds_count{method="get"}`:            6,
+		`gc_seconds{quantile="0.99"}`:                0.2,
+		"gc_seconds_count":                           30,
+	}
+	for key, value := range want {
+		if v, ok := got[key]; !ok || v != value {
+			t.Errorf("%s = %v, want %v (got %v)", key, v, value, got)
+		}
+	}
+
+	samples, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	for _, expr := range []string{
+		`rpc_seconds_bucket{le="1.0"} == 5`,
+		`rpc_seconds_bucket{method="get",le="+Inf"} == 6`,
+		`gc_seconds{quantile="0.99"} < 0.5`,
+	} {
+		a, err := ParseAssertion(expr)
+		if err != nil {
+			t.Fatalf("ParseAssertion(%q): %v", expr, err)
+		}
+		if ok, err := a.Evaluate(samples); !ok {
+			t.Errorf("%s: %v", expr, err)
+		}
+	}
+}
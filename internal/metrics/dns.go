@@ -7,8 +7,28 @@ import (
 )
 
 type DNS struct {
-	Requests  *prometheus.CounterVec
-	Durations *prometheus.HistogramVec
+	Requests     *prometheus.CounterVec
+	Durations    *prometheus.HistogramVec
+	Cache        *prometheus.HistogramVec
+	DNSSECExpiry *prometheus.GaugeVec
+	MinTTL       *prometheus.GaugeVec
+	RecordCount  *prometheus.GaugeVec
+	ResponseSize *prometheus.HistogramVec
+	Truncated    *prometheus.GaugeVec
+
+	NSIDResponses *prometheus.CounterVec
+
+	ZoneRequests        *prometheus.CounterVec
+	ZoneDurations       *prometheus.HistogramVec
+	ZoneSerial          *prometheus.GaugeVec
+	ZoneSerialDrift     *prometheus.GaugeVec
+	ZoneNameservers     *prometheus.GaugeVec
+	ZoneLameDelegations *prometheus.CounterVec
+
+	CompareRequests    *prometheus.CounterVec
+	CompareDurations   *prometheus.HistogramVec
+	CompareComparisons *prometheus.CounterVec
+	CompareMismatches  *prometheus.CounterVec
 }
 
 var (
@@ -22,14 +42,106 @@ func NewDNS(reg prometheus.Registerer) *DNS {
 			Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
 				Name: "health_dns_requests_total",
 				Help: "The number of dns requests",
-			}, []string{"name", "rcode", "rcode_value", "result", "domain", "server"}),
+			}, []string{"name", "rcode", "rcode_value", "result", "domain", "server", "transport"}),
 			Durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
 				Name:    "health_dns_duration_seconds",
 				Help:    "The response time of dns requests",
 				Buckets: []float64{0.001, 0.005, 0.01, 0.02, 0.03, 0.05, 0.075, 0.1, 0.2, 0.5, 0.75, 1, 1.5, 2, 2.5, 3, 4, 5},
-			}, []string{"name", "rcode", "rcode_value", "result", "domain", "server"}),
+			}, []string{"name", "rcode", "rcode_value", "result", "domain", "server", "transport"}),
+			Cache: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "health_dns_cache_duration_seconds",
+				Help:    "The response time of successful dns requests, split by whether the answer came from the resolver cache",
+				Buckets: []float64{0.001, 0.005, 0.01, 0.02, 0.03, 0.05, 0.075, 0.1, 0.2, 0.5, 0.75, 1, 1.5, 2, 2.5, 3, 4, 5},
+			}, []string{"name", "domain", "server", "cache"}),
+			DNSSECExpiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_dns_dnssec_signature_expiry_timestamp_seconds",
+				Help: "The earliest expiration of the RRSIGs made by each zone in the validated chain",
+			}, []string{"name", "domain", "server", "zone"}),
+			MinTTL: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_dns_answer_min_ttl_seconds",
+				Help: "The lowest TTL among the answer records of the last response",
+			}, []string{"name", "domain", "server", "transport"}),
+			RecordCount: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_dns_response_records",
+				Help: "The number of records in each section of the last response",
+			}, []string{"name", "domain", "server", "transport", "section"}),
+			ResponseSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "health_dns_response_size_bytes",
+				Help:    "The size of dns responses, as re-encoded with name compression",
+				Buckets: []float64{64, 128, 256, 512, 1024, 1232, 1452, 2048, 4096, 8192, 16384, 65535},
+			}, []string{"name", "domain", "server", "transport"}),
+			Truncated: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_dns_response_truncated",
+				Help: "Whether the TC flag was set on the last udp response, also when it was then retried over tcp",
+			}, []string{"name", "domain", "server", "transport"}),
+			NSIDResponses: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_dns_nsid_responses_total",
+				Help: "The number of responses carrying each EDNS0 NSID, identifying the anycast node that answered",
+			}, []string{"name", "server", "nsid"}),
+			ZoneRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_dns_zone_requests_total",
+				Help: "The number of SOA queries sent to the authoritative nameservers of a zone",
+			}, []string{"name", "zone", "nameserver", "server", "result"}),
+			ZoneDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "health_dns_zone_duration_seconds",
+				Help:    "The response time of SOA queries to authoritative nameservers",
+				Buckets: []float64{0.001, 0.005, 0.01, 0.02, 0.03, 0.05, 0.075, 0.1, 0.2, 0.5, 0.75, 1, 1.5, 2, 2.5, 3, 4, 5},
+			}, []string{"name", "zone", "nameserver", "server", "result"}),
+			ZoneSerial: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_dns_zone_soa_serial",
+				Help: "The SOA serial last served by each authoritative nameserver",
+			}, []string{"name", "zone", "nameserver", "server"}),
+			ZoneSerialDrift: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_dns_zone_soa_serial_drift",
+				Help: "How far each nameserver's SOA serial is behind the newest serial of the zone",
+			}, []string{"name", "zone", "nameserver", "server"}),
+			ZoneNameservers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_dns_zone_nameservers",
+				Help: "The number of nameservers in the NS set of the zone",
+			}, []string{"name", "zone"}),
+			ZoneLameDelegations: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_dns_zone_lame_delegations_total",
+				Help: "The number of times a delegated nameserver did not answer authoritatively for the zone",
+			}, []string{"name", "zone", "nameserver", "server", "reason"}),
+			CompareRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_dns_compare_requests_total",
+				Help: "The number of queries sent to each server of a comparison target",
+			}, []string{"name", "domain", "server", "rcode", "result"}),
+			CompareDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "health_dns_compare_duration_seconds",
+				Help:    "The response time of each server of a comparison target",
+				Buckets: []float64{0.001, 0.005, 0.01, 0.02, 0.03, 0.05, 0.075, 0.1, 0.2, 0.5, 0.75, 1, 1.5, 2, 2.5, 3, 4, 5},
+			}, []string{"name", "domain", "server", "rcode", "result"}),
+			CompareComparisons: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_dns_compare_comparisons_total",
+				Help: "The number of times the answers of two servers were compared",
+			}, []string{"name", "domain", "server_a", "server_b"}),
+			CompareMismatches: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_dns_compare_mismatches_total",
+				Help: "The number of times two servers returned different rcodes or answer sets",
+			}, []string{"name", "domain", "server_a", "server_b"}),
 		}
-		reg.MustRegister(dnsInst.Requests, dnsInst.Durations)
+		reg.MustRegister(
+			dnsInst.Requests,
+			dnsInst.Durations,
+			dnsInst.Cache,
+			dnsInst.DNSSECExpiry,
+			dnsInst.MinTTL,
+			dnsInst.RecordCount,
+			dnsInst.ResponseSize,
+			dnsInst.Truncated,
+			dnsInst.NSIDResponses,
+			dnsInst.ZoneRequests,
+			dnsInst.ZoneDurations,
+			dnsInst.ZoneSerial,
+			dnsInst.ZoneSerialDrift,
+			dnsInst.ZoneNameservers,
+			dnsInst.ZoneLameDelegations,
+			dnsInst.CompareRequests,
+			dnsInst.CompareDurations,
+			dnsInst.CompareComparisons,
+			dnsInst.CompareMismatches,
+		)
 	})
 	return dnsInst
 }
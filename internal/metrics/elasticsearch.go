@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Elasticsearch struct {
+	Requests            *prometheus.CounterVec
+	Durations           *prometheus.HistogramVec
+	ClusterStatus       *prometheus.GaugeVec
+	UnassignedShards    *prometheus.GaugeVec
+	ActiveShardsPercent *prometheus.GaugeVec
+	Nodes               *prometheus.GaugeVec
+}
+
+var (
+	esOnce sync.Once
+	esInst *Elasticsearch
+)
+
+func NewElasticsearch(reg prometheus.Registerer) *Elasticsearch {
+	esOnce.Do(func() {
+		esInst = &Elasticsearch{
+			Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_elasticsearch_requests_total",
+				Help: "The number of elasticsearch cluster health requests",
+			}, []string{"name", "status_code", "result", "url"}),
+			Durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "health_elasticsearch_duration_seconds",
+				Help:    "The response time of elasticsearch cluster health requests",
+				Buckets: []float64{0.001, 0.005, 0.01, 0.02, 0.03, 0.05, 0.075, 0.1, 0.2, 0.5, 0.75, 1, 1.5, 2, 2.5, 3, 4, 5},
+			}, []string{"name", "status_code", "result", "url"}),
+			ClusterStatus: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_elasticsearch_cluster_status",
+				Help: "The cluster health status, 1 for the current status and 0 for the others",
+			}, []string{"name", "url", "status"}),
+			UnassignedShards: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_elasticsearch_unassigned_shards",
+				Help: "The number of unassigned shards",
+			}, []string{"name", "url"}),
+			ActiveShardsPercent: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_elasticsearch_active_shards_percent",
+				Help: "The percentage of active shards",
+			}, []string{"name", "url"}),
+			Nodes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_elasticsearch_nodes",
+				Help: "The number of nodes in the cluster",
+			}, []string{"name", "url"}),
+		}
+		reg.MustRegister(
+			esInst.Requests,
+			esInst.Durations,
+			esInst.ClusterStatus,
+			esInst.UnassignedShards,
+			esInst.ActiveShardsPercent,
+			esInst.Nodes,
+		)
+	})
+	return esInst
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Exec struct {
+	Requests    *prometheus.CounterVec
+	Durations   *prometheus.HistogramVec
+	OutputValue *prometheus.GaugeVec
+}
+
+var (
+	execOnce sync.Once
+	execInst *Exec
+)
+
+func NewExec(reg prometheus.Registerer) *Exec {
+	execOnce.Do(func() {
+		execInst = &Exec{
+			Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_exec_requests_total",
+				Help: "The number of exec probe runs",
+			}, []string{"name", "exit_code", "result"}),
+			Durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "health_exec_duration_seconds",
+				Help:    "The run time of exec probe commands",
+				Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+			}, []string{"name", "exit_code", "result"}),
+			OutputValue: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_exec_output_value",
+				Help: "The values parsed from exec probe command output",
+			}, []string{"name", "key"}),
+		}
+		reg.MustRegister(execInst.Requests, execInst.Durations, execInst.OutputValue)
+	})
+	return execInst
+}
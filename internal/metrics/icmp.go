@@ -9,6 +9,17 @@ import (
 type ICMP struct {
 	Requests  *prometheus.CounterVec
 	Durations *prometheus.HistogramVec
+
+	PacketLoss *prometheus.GaugeVec
+	RTT        *prometheus.GaugeVec
+	Jitter     *prometheus.GaugeVec
+	Duplicates *prometheus.CounterVec
+	OutOfOrder *prometheus.CounterVec
+
+	PathMTU *prometheus.GaugeVec
+
+	FlowPacketLoss *prometheus.GaugeVec
+	FlowRTT        *prometheus.GaugeVec
 }
 
 var (
@@ -22,14 +33,57 @@ func NewICMP(reg prometheus.Registerer) *ICMP {
 			Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
 				Name: "health_icmp_requests_total",
 				Help: "The number of icmp requests",
-			}, []string{"name", "ttl", "result", "host"}),
+			}, []string{"name", "ttl", "result", "host", "ip_version"}),
 			Durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
 				Name:    "health_icmp_duration_seconds",
 				Help:    "The response time of icmp requests",
 				Buckets: []float64{0.001, 0.005, 0.01, 0.02, 0.03, 0.05, 0.075, 0.1, 0.2, 0.5, 0.75, 1, 1.5, 2, 2.5, 3, 4, 5},
-			}, []string{"name", "ttl", "result", "host"}),
+			}, []string{"name", "ttl", "result", "host", "ip_version"}),
+			PacketLoss: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_icmp_packet_loss_ratio",
+				Help: "The ratio of echoes of the last burst that got no reply",
+			}, []string{"name", "host", "ip_version"}),
+			RTT: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_icmp_rtt_seconds",
+				Help: "The min, avg and max round-trip time of the last burst",
+			}, []string{"name", "host", "ip_version", "stat"}),
+			Jitter: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_icmp_rtt_stddev_seconds",
+				Help: "The standard deviation of the round-trip times of the last burst",
+			}, []string{"name", "host", "ip_version"}),
+			Duplicates: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_icmp_duplicate_replies_total",
+				Help: "The number of duplicate echo replies",
+			}, []string{"name", "host", "ip_version"}),
+			OutOfOrder: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_icmp_out_of_order_replies_total",
+				Help: "The number of echo replies that arrived after a reply to a later echo",
+			}, []string{"name", "host", "ip_version"}),
+			PathMTU: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_icmp_path_mtu_bytes",
+				Help: "The largest packet that last reached the host without fragmentation",
+			}, []string{"name", "host", "ip_version"}),
+			FlowPacketLoss: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_icmp_flow_packet_loss_ratio",
+				Help: "The ratio of echoes of each flow's last burst that got no reply",
+			}, []string{"name", "host", "ip_version", "flow"}),
+			FlowRTT: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_icmp_flow_rtt_seconds",
+				Help: "The average round-trip time of each flow's last burst",
+			}, []string{"name", "host", "ip_version", "flow"}),
 		}
-		reg.MustRegister(icmpInst.Requests, icmpInst.Durations)
+		reg.MustRegister(
+			icmpInst.Requests,
+			icmpInst.Durations,
+			icmpInst.PacketLoss,
+			icmpInst.RTT,
+			icmpInst.Jitter,
+			icmpInst.Duplicates,
+			icmpInst.OutOfOrder,
+			icmpInst.PathMTU,
+			icmpInst.FlowPacketLoss,
+			icmpInst.FlowRTT,
+		)
 	})
 	return icmpInst
 }
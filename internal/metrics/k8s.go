@@ -1,17 +1,21 @@
 package metrics
 
 import (
-	"context"
-	"net/url"
+	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"k8s.io/client-go/tools/metrics"
+	"k8s.io/client-go/rest"
 )
 
 type K8S struct {
 	PodCount *prometheus.GaugeVec
+
+	Requests    *prometheus.CounterVec
+	Durations   *prometheus.HistogramVec
+	WatchErrors *prometheus.CounterVec
 }
 
 var (
@@ -20,7 +24,7 @@ var (
 			Name: "health_k8s_http_request_total",
 			Help: "Total number of HTTP requests to the Kubernetes API by status code.",
 		},
-		[]string{"status_code"},
+		[]string{"cluster", "status_code"},
 	)
 	clientGoRequestLatencyMetricVec = prometheus.NewSummaryVec(
 		prometheus.SummaryOpts{
@@ -28,29 +32,44 @@ var (
 			Help:       "Summary of latencies for HTTP requests to the Kubernetes API by endpoint.",
 			Objectives: map[float64]float64{},
 		},
-		[]string{"endpoint"},
+		[]string{"cluster", "endpoint"},
 	)
 	registerClientMetricsOnce sync.Once
 	k8sMetricsOnce            sync.Once
 	k8sMetricsInst            *K8S
 )
 
-type clientGoRequestMetricAdapter struct{}
+// k8sRoundTripper records the requests of one cluster's clients. It wraps the
+// transport of their rest.Config rather than hooking client-go's global
+// metrics, which only see the URL and so cannot tell apart clusters that
+// share an API server.
+type k8sRoundTripper struct {
+	next    http.RoundTripper
+	cluster string
+}
 
-func (clientGoRequestMetricAdapter) Increment(_ context.Context, code string, _ string, _ string) {
-	clientGoRequestResultMetricVec.WithLabelValues(code).Inc()
+func (t k8sRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	clientGoRequestLatencyMetricVec.WithLabelValues(t.cluster, req.URL.EscapedPath()).Observe(time.Since(start).Seconds())
+	code := "<error>"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	clientGoRequestResultMetricVec.WithLabelValues(t.cluster, code).Inc()
+	return resp, err
 }
 
-func (clientGoRequestMetricAdapter) Observe(_ context.Context, _ string, u url.URL, latency time.Duration) {
-	clientGoRequestLatencyMetricVec.WithLabelValues(u.EscapedPath()).Observe(latency.Seconds())
+// InstrumentK8SConfig labels the requests of every client built from cfg
+// with cluster.
+func InstrumentK8SConfig(cfg *rest.Config, cluster string) {
+	cfg.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return k8sRoundTripper{next: rt, cluster: cluster}
+	})
 }
 
 func RegisterClientGoMetrics(reg prometheus.Registerer) {
 	registerClientMetricsOnce.Do(func() {
-		metrics.Register(metrics.RegisterOpts{
-			RequestLatency: clientGoRequestMetricAdapter{},
-			RequestResult:  clientGoRequestMetricAdapter{},
-		})
 		reg.MustRegister(
 			clientGoRequestResultMetricVec,
 			clientGoRequestLatencyMetricVec,
@@ -64,9 +83,27 @@ func NewK8S(reg prometheus.Registerer) *K8S {
 			PodCount: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 				Name: "health_k8s_pod_count",
 				Help: "The number of pods in namespace",
-			}, []string{"namespace"}),
+			}, []string{"cluster", "namespace"}),
+			Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_k8s_requests_total",
+				Help: "The number of latency probe requests to the Kubernetes API",
+			}, []string{"cluster", "namespace", "result"}),
+			Durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "health_k8s_duration_seconds",
+				Help:    "The response time of latency probe requests to the Kubernetes API",
+				Buckets: []float64{0.005, 0.01, 0.02, 0.03, 0.05, 0.075, 0.1, 0.2, 0.5, 0.75, 1, 1.5, 2, 2.5, 3, 4, 5},
+			}, []string{"cluster", "namespace", "result"}),
+			WatchErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_k8s_watch_errors_total",
+				Help: "The number of times the informer behind the pod count failed to list or watch pods",
+			}, []string{"cluster", "namespace"}),
 		}
-		reg.MustRegister(k8sMetricsInst.PodCount)
+		reg.MustRegister(
+			k8sMetricsInst.PodCount,
+			k8sMetricsInst.Requests,
+			k8sMetricsInst.Durations,
+			k8sMetricsInst.WatchErrors,
+		)
 	})
 	return k8sMetricsInst
 }
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Prometheus struct {
+	Requests         *prometheus.CounterVec
+	Durations        *prometheus.HistogramVec
+	Samples          *prometheus.GaugeVec
+	AssertionSuccess *prometheus.GaugeVec
+}
+
+var (
+	promOnce sync.Once
+	promInst *Prometheus
+)
+
+func NewPrometheus(reg prometheus.Registerer) *Prometheus {
+	promOnce.Do(func() {
+		promInst = &Prometheus{
+			Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_prometheus_requests_total",
+				Help: "The number of metrics endpoint scrapes",
+			}, []string{"name", "status_code", "result", "url"}),
+			Durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "health_prometheus_scrape_duration_seconds",
+				Help:    "The time taken to fetch and parse metrics endpoints",
+				Buckets: []float64{0.001, 0.005, 0.01, 0.02, 0.03, 0.05, 0.075, 0.1, 0.2, 0.5, 0.75, 1, 1.5, 2, 2.5, 3, 4, 5},
+			}, []string{"name", "status_code", "result", "url"}),
+			Samples: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_prometheus_scrape_samples",
+				Help: "The number of samples exposed by the last successful scrape",
+			}, []string{"name", "url"}),
+			AssertionSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_prometheus_assertion_success",
+				Help: "Whether the assertion held on the last successful scrape",
+			}, []string{"name", "url", "assertion"}),
+		}
+		reg.MustRegister(promInst.Requests, promInst.Durations, promInst.Samples, promInst.AssertionSuccess)
+	})
+	return promInst
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type S3 struct {
+	Requests  *prometheus.CounterVec
+	Durations *prometheus.HistogramVec
+}
+
+var (
+	s3Once sync.Once
+	s3Inst *S3
+)
+
+func NewS3(reg prometheus.Registerer) *S3 {
+	s3Once.Do(func() {
+		s3Inst = &S3{
+			Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_s3_requests_total",
+				Help: "The number of s3 object operations",
+			}, []string{"name", "operation", "status_code", "result", "endpoint", "bucket"}),
+			Durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "health_s3_duration_seconds",
+				Help:    "The response time of s3 object operations",
+				Buckets: []float64{0.001, 0.005, 0.01, 0.02, 0.03, 0.05, 0.075, 0.1, 0.2, 0.5, 0.75, 1, 1.5, 2, 2.5, 3, 4, 5},
+			}, []string{"name", "operation", "status_code", "result", "endpoint", "bucket"}),
+		}
+		reg.MustRegister(s3Inst.Requests, s3Inst.Durations)
+	})
+	return s3Inst
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type SSH struct {
+	Requests   *prometheus.CounterVec
+	Durations  *prometheus.HistogramVec
+	ServerInfo *prometheus.GaugeVec
+}
+
+var (
+	sshOnce sync.Once
+	sshInst *SSH
+)
+
+func NewSSH(reg prometheus.Registerer) *SSH {
+	sshOnce.Do(func() {
+		sshInst = &SSH{
+			Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_ssh_requests_total",
+				Help: "The number of ssh requests",
+			}, []string{"name", "result", "host"}),
+			Durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "health_ssh_duration_seconds",
+				Help:    "The handshake time of ssh requests",
+				Buckets: []float64{0.001, 0.005, 0.01, 0.02, 0.03, 0.05, 0.075, 0.1, 0.2, 0.5, 0.75, 1, 1.5, 2, 2.5, 3, 4, 5},
+			}, []string{"name", "result", "host"}),
+			ServerInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_ssh_server_info",
+				Help: "The version banner and host key fingerprint presented by the ssh server",
+			}, []string{"name", "host", "version", "host_key_fingerprint"}),
+		}
+		reg.MustRegister(sshInst.Requests, sshInst.Durations, sshInst.ServerInfo)
+	})
+	return sshInst
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Traceroute struct {
+	HopRTT      *prometheus.GaugeVec
+	HopLoss     *prometheus.GaugeVec
+	Hops        *prometheus.GaugeVec
+	Reached     *prometheus.GaugeVec
+	PathChanges *prometheus.CounterVec
+
+	FlowLoss *prometheus.GaugeVec
+	FlowRTT  *prometheus.GaugeVec
+}
+
+var (
+	tracerouteOnce sync.Once
+	tracerouteInst *Traceroute
+)
+
+func NewTraceroute(reg prometheus.Registerer) *Traceroute {
+	tracerouteOnce.Do(func() {
+		tracerouteInst = &Traceroute{
+			HopRTT: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_traceroute_hop_rtt_seconds",
+				Help: "The average round-trip time to each address answering at a hop on the last run",
+			}, []string{"name", "host", "hop", "address"}),
+			HopLoss: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_traceroute_hop_loss_ratio",
+				Help: "The ratio of probes to a hop that got no answer on the last run",
+			}, []string{"name", "host", "hop", "address"}),
+			Hops: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_traceroute_hops",
+				Help: "The number of hops on the path found by the last run",
+			}, []string{"name", "host"}),
+			Reached: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_traceroute_destination_reached",
+				Help: "Whether the last run got an answer from the host",
+			}, []string{"name", "host"}),
+			PathChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_traceroute_path_changes_total",
+				Help: "The number of times the path to the host changed between runs",
+			}, []string{"name", "host"}),
+			FlowLoss: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_traceroute_flow_loss_ratio",
+				Help: "The ratio of probes of each flow that did not reach the host on the last run",
+			}, []string{"name", "host", "flow"}),
+			FlowRTT: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_traceroute_flow_rtt_seconds",
+				Help: "The average round-trip time to the host of each flow on the last run",
+			}, []string{"name", "host", "flow"}),
+		}
+		reg.MustRegister(
+			tracerouteInst.HopRTT,
+			tracerouteInst.HopLoss,
+			tracerouteInst.Hops,
+			tracerouteInst.Reached,
+			tracerouteInst.PathChanges,
+			tracerouteInst.FlowLoss,
+			tracerouteInst.FlowRTT,
+		)
+	})
+	return tracerouteInst
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type TWAMP struct {
+	Requests  *prometheus.CounterVec
+	Durations *prometheus.HistogramVec
+
+	PacketLoss  *prometheus.GaugeVec
+	RTT         *prometheus.GaugeVec
+	Jitter      *prometheus.GaugeVec
+	OneWayDelay *prometheus.GaugeVec
+	Reordered   *prometheus.CounterVec
+	Duplicates  *prometheus.CounterVec
+	ClockSynced *prometheus.GaugeVec
+
+	Reflected *prometheus.CounterVec
+}
+
+var (
+	twampOnce sync.Once
+	twampInst *TWAMP
+)
+
+func NewTWAMP(reg prometheus.Registerer) *TWAMP {
+	twampOnce.Do(func() {
+		twampInst = &TWAMP{
+			Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_twamp_requests_total",
+				Help: "The number of twamp test packets",
+			}, []string{"name", "address", "result"}),
+			Durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "health_twamp_duration_seconds",
+				Help:    "The round-trip time of twamp test packets, without the time spent in the reflector",
+				Buckets: []float64{0.001, 0.005, 0.01, 0.02, 0.03, 0.05, 0.075, 0.1, 0.2, 0.5, 0.75, 1, 1.5, 2, 2.5, 3, 4, 5},
+			}, []string{"name", "address", "result"}),
+			PacketLoss: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_twamp_packet_loss_ratio",
+				Help: "The ratio of test packets of the last session that were not reflected",
+			}, []string{"name", "address"}),
+			RTT: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_twamp_rtt_seconds",
+				Help: "The min, avg and max round-trip time of the last session, without the time spent in the reflector",
+			}, []string{"name", "address", "stat"}),
+			Jitter: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_twamp_jitter_seconds",
+				Help: "The mean difference between the delays of consecutive test packets of the last session",
+			}, []string{"name", "address", "direction"}),
+			OneWayDelay: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_twamp_one_way_delay_seconds",
+				Help: "The min, avg and max one-way delay of the last session, only when both clocks are synchronized",
+			}, []string{"name", "address", "direction", "stat"}),
+			Reordered: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_twamp_reordered_packets_total",
+				Help: "The number of test packets reflected after a later one",
+			}, []string{"name", "address"}),
+			Duplicates: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_twamp_duplicate_packets_total",
+				Help: "The number of test packets reflected more than once",
+			}, []string{"name", "address"}),
+			ClockSynced: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "health_twamp_clock_synchronized",
+				Help: "Whether both the sender and the reflector reported a synchronized clock on the last session",
+			}, []string{"name", "address"}),
+			Reflected: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "health_twamp_reflected_packets_total",
+				Help: "The number of test packets answered by the reflector",
+			}, []string{"listen"}),
+		}
+		reg.MustRegister(
+			twampInst.Requests,
+			twampInst.Durations,
+			twampInst.PacketLoss,
+			twampInst.RTT,
+			twampInst.Jitter,
+			twampInst.OneWayDelay,
+			twampInst.Reordered,
+			twampInst.Duplicates,
+			twampInst.ClockSynced,
+			twampInst.Reflected,
+		)
+	})
+	return twampInst
+}
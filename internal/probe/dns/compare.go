@@ -0,0 +1,152 @@
+package dns
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	klog "k8s.io/klog/v2"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/metrics"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/probe"
+)
+
+// CompareProbe sends the same question to a set of resolvers and reports
+// every pair of servers whose answers differ.
+type CompareProbe struct {
+	target   config.DNSCompareTarget
+	client   exchanger
+	metrics  *metrics.DNS
+	interval time.Duration
+}
+
+// answerSet is the comparable form of a response: its rcode and the sorted
+// answer records without TTLs, which legitimately differ between caches.
+type answerSet struct {
+	ok      bool
+	rcode   int
+	records []string
+}
+
+func NewCompare(target config.DNSCompareTarget, m *metrics.DNS) *CompareProbe {
+	return &CompareProbe{
+		target:   target,
+		client:   newFallbackExchanger(target.Timeout),
+		metrics:  m,
+		interval: probe.IntervalFromRPS(target.RPS),
+	}
+}
+
+func (p *CompareProbe) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			go p.probeOnce(ctx)
+		}
+	}
+}
+
+func (p *CompareProbe) probeOnce(ctx context.Context) {
+	answers := make([]answerSet, len(p.target.Servers))
+	var wg sync.WaitGroup
+	for i, server := range p.target.Servers {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			answers[i] = p.query(ctx, server)
+		}(i, server)
+	}
+	wg.Wait()
+
+	// Servers that failed to answer have nothing to compare; their failure is
+	// already visible in the request counters.
+	for i := range answers {
+		if !answers[i].ok {
+			continue
+		}
+		for j := i + 1; j < len(answers); j++ {
+			if !answers[j].ok {
+				continue
+			}
+			labels := prometheus.Labels{
+				"name":     p.target.Name,
+				"domain":   p.target.Domain,
+				"server_a": p.target.Servers[i],
+				"server_b": p.target.Servers[j],
+			}
+			p.metrics.CompareComparisons.With(labels).Inc()
+			if !answers[i].equal(answers[j]) {
+				klog.V(4).Infof("dns compare probe %q: %s answered %v, %s answered %v", p.target.Name,
+					p.target.Servers[i], answers[i].records, p.target.Servers[j], answers[j].records)
+				p.metrics.CompareMismatches.With(labels).Inc()
+			}
+		}
+	}
+}
+
+func (p *CompareProbe) query(ctx context.Context, server string) answerSet {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(p.target.Domain), p.target.QType)
+	msg.RecursionDesired = true
+	msg.SetEdns0(dns.DefaultMsgSize, false)
+
+	resp, rtt, err := p.client.exchange(ctx, msg, server)
+	labels := prometheus.Labels{
+		"name":   p.target.Name,
+		"domain": p.target.Domain,
+		"server": server,
+		"rcode":  "",
+		"result": "success",
+	}
+	if err != nil {
+		labels["result"] = classifyDNSError(err)
+	} else {
+		labels["rcode"] = dns.RcodeToString[resp.Rcode]
+		if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+			labels["result"] = "error"
+		}
+	}
+	p.metrics.CompareRequests.With(labels).Inc()
+	p.metrics.CompareDurations.With(labels).Observe(float64(rtt) / float64(time.Second))
+
+	if err != nil {
+		return answerSet{}
+	}
+	return newAnswerSet(resp)
+}
+
+func newAnswerSet(resp *dns.Msg) answerSet {
+	set := answerSet{ok: true, rcode: resp.Rcode}
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = 0
+		rr.Header().Name = dns.CanonicalName(rr.Header().Name)
+		set.records = append(set.records, rr.String())
+	}
+	sort.Strings(set.records)
+	return set
+}
+
+func (a answerSet) equal(b answerSet) bool {
+	if a.rcode != b.rcode || len(a.records) != len(b.records) {
+		return false
+	}
+	for i := range a.records {
+		if a.records[i] != b.records[i] {
+			return false
+		}
+	}
+	return true
+}
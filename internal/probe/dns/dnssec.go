@@ -0,0 +1,323 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+)
+
+// maxChainDepth bounds how many zone cuts are followed towards a trust anchor.
+const maxChainDepth = 16
+
+var errNoSignature = errors.New("no valid signature")
+
+type dnssecValidator struct {
+	client  exchanger
+	anchors map[string][]dns.RR
+
+	mu   sync.Mutex
+	keys map[string]trustedZone
+}
+
+// trustedZone is a validated DNSKEY set, kept until the first of its TTL, the
+// TTL of its DS set and the expiration of any signature on its chain.
+type trustedZone struct {
+	keys   []*dns.DNSKEY
+	until  time.Time
+	expiry map[string]time.Time
+}
+
+func newDNSSECValidator(cfg config.DNSSEC, client exchanger) (*dnssecValidator, error) {
+	v := &dnssecValidator{
+		client:  client,
+		anchors: make(map[string][]dns.RR),
+		keys:    make(map[string]trustedZone),
+	}
+	for _, anchor := range cfg.TrustAnchors {
+		rr, err := dns.NewRR(anchor)
+		if err != nil {
+			return nil, fmt.Errorf("trust anchor %q: %w", anchor, err)
+		}
+		zone := dns.CanonicalName(rr.Header().Name)
+		v.anchors[zone] = append(v.anchors[zone], rr)
+	}
+	return v, nil
+}
+
+// chainState collects the earliest signature expiration per signer zone seen
+// while validating a response.
+type chainState struct {
+	now    time.Time
+	server string
+	expiry map[string]time.Time
+}
+
+func (s *chainState) observe(sig *dns.RRSIG) {
+	zone := dns.CanonicalName(sig.SignerName)
+	expiration := time.Unix(int64(sig.Expiration), 0)
+	if current, ok := s.expiry[zone]; !ok || expiration.Before(current) {
+		s.expiry[zone] = expiration
+	}
+}
+
+// merge takes over the signature expirations collected by another state.
+func (s *chainState) merge(expiry map[string]time.Time) {
+	for zone, expiration := range expiry {
+		if current, ok := s.expiry[zone]; !ok || expiration.Before(current) {
+			s.expiry[zone] = expiration
+		}
+	}
+}
+
+// validate verifies every RRset in the answer section of resp up to a trust
+// anchor. Denial of existence (NSEC/NSEC3) is not validated, so responses
+// without answers are accepted as-is.
+func (v *dnssecValidator) validate(ctx context.Context, resp *dns.Msg, server string) (map[string]time.Time, error) {
+	state := &chainState{
+		now:    time.Now(),
+		server: server,
+		expiry: make(map[string]time.Time),
+	}
+
+	rrsets, sigs := splitRRsets(resp.Answer)
+	for key, rrset := range rrsets {
+		if err := v.verifyRRset(ctx, state, rrset, sigs[key], 0); err != nil {
+			return state.expiry, fmt.Errorf("%s %s: %w", rrset[0].Header().Name, dns.TypeToString[rrset[0].Header().Rrtype], err)
+		}
+	}
+	return state.expiry, nil
+}
+
+func (v *dnssecValidator) verifyRRset(ctx context.Context, state *chainState, rrset []dns.RR, sigs []*dns.RRSIG, depth int) error {
+	if len(sigs) == 0 {
+		return errors.New("rrset is not signed")
+	}
+
+	// RRSIG.Verify only matches the signer to the key owner, so a zone that
+	// chains to the anchor could otherwise sign names outside itself.
+	owner := dns.CanonicalName(rrset[0].Header().Name)
+	var lastErr error = errNoSignature
+	for _, sig := range sigs {
+		signer := dns.CanonicalName(sig.SignerName)
+		if !dns.IsSubDomain(signer, owner) {
+			lastErr = fmt.Errorf("signature by %s does not cover %s", signer, owner)
+			continue
+		}
+		keys, err := v.trustedKeys(ctx, state, signer, depth)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if err := verifyWithKeys(state, sig, keys, rrset); err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// trustedKeys returns the DNSKEY set of zone, from the cache while it is still
+// fresh and otherwise validated with validateKeys.
+func (v *dnssecValidator) trustedKeys(ctx context.Context, state *chainState, zone string, depth int) ([]*dns.DNSKEY, error) {
+	if depth > maxChainDepth {
+		return nil, fmt.Errorf("chain of trust deeper than %d zones", maxChainDepth)
+	}
+
+	key := state.server + " " + zone
+	v.mu.Lock()
+	cached, ok := v.keys[key]
+	if ok && !state.now.Before(cached.until) {
+		delete(v.keys, key)
+		ok = false
+	}
+	v.mu.Unlock()
+	if ok {
+		state.merge(cached.expiry)
+		return cached.keys, nil
+	}
+
+	// Validate against a state of its own so the cache entry knows every
+	// signature expiration on the chain below the zone.
+	zoneState := &chainState{
+		now:    state.now,
+		server: state.server,
+		expiry: make(map[string]time.Time),
+	}
+	keys, ttl, err := v.validateKeys(ctx, zoneState, zone, depth)
+	state.merge(zoneState.expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	until := state.now.Add(ttl)
+	for _, expiration := range zoneState.expiry {
+		if expiration.Before(until) {
+			until = expiration
+		}
+	}
+	v.mu.Lock()
+	v.keys[key] = trustedZone{keys: keys, until: until, expiry: zoneState.expiry}
+	v.mu.Unlock()
+	return keys, nil
+}
+
+// validateKeys fetches the DNSKEY set of zone and checks that it is signed by
+// a key that is either a configured trust anchor or vouched for by a
+// validated DS record in the parent zone. It also returns the lowest TTL of
+// the DNSKEY and DS sets.
+func (v *dnssecValidator) validateKeys(ctx context.Context, state *chainState, zone string, depth int) ([]*dns.DNSKEY, time.Duration, error) {
+	rrs, sigs, err := v.query(ctx, state.server, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, 0, fmt.Errorf("fetch %s DNSKEY: %w", zone, err)
+	}
+	var keys []*dns.DNSKEY
+	for _, rr := range rrs {
+		if key, ok := rr.(*dns.DNSKEY); ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, 0, fmt.Errorf("zone %s has no DNSKEY", zone)
+	}
+	ttl, _ := minTTL(rrs)
+
+	var entryPoints []*dns.DNSKEY
+	if anchors, ok := v.anchors[zone]; ok {
+		entryPoints = matchAnchors(keys, anchors)
+	} else {
+		if zone == "." {
+			return nil, 0, errors.New("no trust anchor configured for the chain")
+		}
+		dsRRs, dsSigs, err := v.query(ctx, state.server, zone, dns.TypeDS)
+		if err != nil {
+			return nil, 0, fmt.Errorf("fetch %s DS: %w", zone, err)
+		}
+		if len(dsRRs) == 0 {
+			return nil, 0, fmt.Errorf("zone %s has no DS record in its parent", zone)
+		}
+		// The DS set lives in the parent, so the zone cannot vouch for itself.
+		var parentSigs []*dns.RRSIG
+		for _, sig := range dsSigs {
+			if dns.CanonicalName(sig.SignerName) != zone {
+				parentSigs = append(parentSigs, sig)
+			}
+		}
+		if err := v.verifyRRset(ctx, state, dsRRs, parentSigs, depth+1); err != nil {
+			return nil, 0, fmt.Errorf("%s DS: %w", zone, err)
+		}
+		entryPoints = matchAnchors(keys, dsRRs)
+		if dsTTL, _ := minTTL(dsRRs); dsTTL < ttl {
+			ttl = dsTTL
+		}
+	}
+	if len(entryPoints) == 0 {
+		return nil, 0, fmt.Errorf("no DNSKEY of %s matches its trust anchor or DS", zone)
+	}
+
+	for _, sig := range sigs {
+		if verifyWithKeys(state, sig, entryPoints, rrs) == nil {
+			return keys, time.Duration(ttl) * time.Second, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("%s DNSKEY: %w", zone, errNoSignature)
+}
+
+func (v *dnssecValidator) query(ctx context.Context, server, name string, qtype uint16) ([]dns.RR, []*dns.RRSIG, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.RecursionDesired = true
+	msg.CheckingDisabled = true
+	msg.SetEdns0(dns.DefaultMsgSize, true)
+
+	resp, _, err := v.client.exchange(ctx, msg, server)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, nil, fmt.Errorf("rcode %s", dns.RcodeToString[resp.Rcode])
+	}
+
+	var rrs []dns.RR
+	var sigs []*dns.RRSIG
+	for _, rr := range resp.Answer {
+		if !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+		switch v := rr.(type) {
+		case *dns.RRSIG:
+			if v.TypeCovered == qtype {
+				sigs = append(sigs, v)
+			}
+		default:
+			if rr.Header().Rrtype == qtype {
+				rrs = append(rrs, rr)
+			}
+		}
+	}
+	return rrs, sigs, nil
+}
+
+func verifyWithKeys(state *chainState, sig *dns.RRSIG, keys []*dns.DNSKEY, rrset []dns.RR) error {
+	if !sig.ValidityPeriod(state.now) {
+		return fmt.Errorf("signature by %s (tag %d) is outside its validity period", sig.SignerName, sig.KeyTag)
+	}
+	for _, key := range keys {
+		if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+			continue
+		}
+		if err := sig.Verify(key, rrset); err == nil {
+			state.observe(sig)
+			return nil
+		}
+	}
+	return fmt.Errorf("signature by %s (tag %d): %w", sig.SignerName, sig.KeyTag, errNoSignature)
+}
+
+func matchAnchors(keys []*dns.DNSKEY, anchors []dns.RR) []*dns.DNSKEY {
+	var matched []*dns.DNSKEY
+	for _, key := range keys {
+		for _, anchor := range anchors {
+			switch a := anchor.(type) {
+			case *dns.DS:
+				ds := key.ToDS(a.DigestType)
+				if ds != nil && ds.KeyTag == a.KeyTag && ds.Algorithm == a.Algorithm && strings.EqualFold(ds.Digest, a.Digest) {
+					matched = append(matched, key)
+				}
+			case *dns.DNSKEY:
+				if key.Algorithm == a.Algorithm && key.PublicKey == a.PublicKey {
+					matched = append(matched, key)
+				}
+			}
+		}
+	}
+	return matched
+}
+
+type rrsetKey struct {
+	name  string
+	rtype uint16
+}
+
+func splitRRsets(rrs []dns.RR) (map[rrsetKey][]dns.RR, map[rrsetKey][]*dns.RRSIG) {
+	rrsets := make(map[rrsetKey][]dns.RR)
+	sigs := make(map[rrsetKey][]*dns.RRSIG)
+	for _, rr := range rrs {
+		name := dns.CanonicalName(rr.Header().Name)
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			key := rrsetKey{name: name, rtype: sig.TypeCovered}
+			sigs[key] = append(sigs[key], sig)
+			continue
+		}
+		key := rrsetKey{name: name, rtype: rr.Header().Rrtype}
+		rrsets[key] = append(rrsets[key], rr)
+	}
+	return rrsets, sigs
+}
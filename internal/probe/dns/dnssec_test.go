@@ -0,0 +1,193 @@
+package dns
+
+import (
+	"context"
+	"crypto"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+)
+
+// signedZone is a zone with its key and signed records, answered by
+// zoneExchanger.
+type signedZone struct {
+	key    *dns.DNSKEY
+	signer crypto.Signer
+	rrsets map[uint16][]dns.RR
+}
+
+func newSignedZone(t *testing.T, name string) *signedZone {
+	t.Helper()
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatalf("generate %s key: %v", name, err)
+	}
+	z := &signedZone{key: key, signer: priv.(crypto.Signer), rrsets: make(map[uint16][]dns.RR)}
+	z.rrsets[dns.TypeDNSKEY] = []dns.RR{key}
+	return z
+}
+
+// sign signs rrset with the key of z and returns it with its signature.
+func (z *signedZone) sign(t *testing.T, rrset []dns.RR) []dns.RR {
+	t.Helper()
+	now := time.Now()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Ttl: rrset[0].Header().Ttl},
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.key.Hdr.Name,
+		Algorithm:  z.key.Algorithm,
+		Inception:  uint32(now.Add(-time.Hour).Unix()),
+		Expiration: uint32(now.Add(24 * time.Hour).Unix()),
+	}
+	if err := sig.Sign(z.signer, rrset); err != nil {
+		t.Fatalf("sign %s: %v", rrset[0].Header().Name, err)
+	}
+	return append(append([]dns.RR{}, rrset...), sig)
+}
+
+// zoneExchanger answers from a fixed set of signed answers and counts the
+// queries per type.
+type zoneExchanger struct {
+	answers map[rrsetKey][]dns.RR
+
+	mu      sync.Mutex
+	queries map[uint16]int
+}
+
+func (e *zoneExchanger) exchange(_ context.Context, msg *dns.Msg, _ string) (*dns.Msg, time.Duration, error) {
+	q := msg.Question[0]
+	e.mu.Lock()
+	e.queries[q.Qtype]++
+	e.mu.Unlock()
+	resp := new(dns.Msg)
+	resp.SetReply(msg)
+	resp.Answer = e.answers[rrsetKey{name: dns.CanonicalName(q.Name), rtype: q.Qtype}]
+	return resp, 0, nil
+}
+
+func (e *zoneExchanger) count(qtype uint16) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.queries[qtype]
+}
+
+func TestTrustedKeysCache(t *testing.T) {
+	cases := []struct {
+		name    string
+		dsTTL   uint32
+		fetches int
+	}{
+		{name: "cached-until-ttl", dsTTL: 3600, fetches: 1},
+		{name: "expired-ttl", dsTTL: 0, fetches: 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := newSignedZone(t, ".")
+			child := newSignedZone(t, "example.")
+			ds := child.key.ToDS(dns.SHA256)
+			ds.Hdr.Ttl = tc.dsTTL
+			a, err := dns.NewRR("www.example. 300 IN A 192.0.2.1")
+			if err != nil {
+				t.Fatalf("parse A: %v", err)
+			}
+			answer := child.sign(t, []dns.RR{a})
+
+			client := &zoneExchanger{
+				answers: map[rrsetKey][]dns.RR{
+					{name: ".", rtype: dns.TypeDNSKEY}:        root.sign(t, root.rrsets[dns.TypeDNSKEY]),
+					{name: "example.", rtype: dns.TypeDNSKEY}: child.sign(t, child.rrsets[dns.TypeDNSKEY]),
+					{name: "example.", rtype: dns.TypeDS}:     root.sign(t, []dns.RR{ds}),
+				},
+				queries: make(map[uint16]int),
+			}
+			v, err := newDNSSECValidator(config.DNSSEC{TrustAnchors: []string{root.key.String()}}, client)
+			if err != nil {
+				t.Fatalf("new validator: %v", err)
+			}
+
+			resp := new(dns.Msg)
+			resp.Answer = answer
+			for i := 0; i < 2; i++ {
+				expiry, err := v.validate(context.Background(), resp, "192.0.2.53:53")
+				if err != nil {
+					t.Fatalf("validate #%d: %v", i+1, err)
+				}
+				for _, zone := range []string{".", "example."} {
+					if _, ok := expiry[zone]; !ok {
+						t.Fatalf("validate #%d: no signature expiration for %q", i+1, zone)
+					}
+				}
+			}
+
+			if got := client.count(dns.TypeDS); got != tc.fetches {
+				t.Errorf("DS fetched %d times, want %d", got, tc.fetches)
+			}
+			if got := client.count(dns.TypeDNSKEY); got != tc.fetches+1 {
+				t.Errorf("DNSKEY fetched %d times, want %d", got, tc.fetches+1)
+			}
+		})
+	}
+}
+
+func TestValidateSignerScope(t *testing.T) {
+	root := newSignedZone(t, ".")
+	example := newSignedZone(t, "example.")
+	evil := newSignedZone(t, "evil.example.")
+	zones := map[string]*signedZone{"example.": example, "evil.example.": evil}
+
+	cases := []struct {
+		name    string
+		owner   string
+		signer  string
+		selfDS  bool
+		wantErr bool
+	}{
+		{name: "own-zone", owner: "www.evil.example.", signer: "evil.example."},
+		{name: "ancestor-zone", owner: "www.evil.example.", signer: "example."},
+		{name: "sibling-zone", owner: "bank.example.", signer: "evil.example.", wantErr: true},
+		{name: "self-signed-ds", owner: "www.evil.example.", signer: "evil.example.", selfDS: true, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dsSigner := example
+			if tc.selfDS {
+				dsSigner = evil
+			}
+			client := &zoneExchanger{
+				answers: map[rrsetKey][]dns.RR{
+					{name: ".", rtype: dns.TypeDNSKEY}:             root.sign(t, root.rrsets[dns.TypeDNSKEY]),
+					{name: "example.", rtype: dns.TypeDNSKEY}:      example.sign(t, example.rrsets[dns.TypeDNSKEY]),
+					{name: "example.", rtype: dns.TypeDS}:          root.sign(t, []dns.RR{example.key.ToDS(dns.SHA256)}),
+					{name: "evil.example.", rtype: dns.TypeDNSKEY}: evil.sign(t, evil.rrsets[dns.TypeDNSKEY]),
+					{name: "evil.example.", rtype: dns.TypeDS}:     dsSigner.sign(t, []dns.RR{evil.key.ToDS(dns.SHA256)}),
+				},
+				queries: make(map[uint16]int),
+			}
+			v, err := newDNSSECValidator(config.DNSSEC{TrustAnchors: []string{root.key.String()}}, client)
+			if err != nil {
+				t.Fatalf("new validator: %v", err)
+			}
+
+			a, err := dns.NewRR(tc.owner + " 300 IN A 192.0.2.1")
+			if err != nil {
+				t.Fatalf("parse A: %v", err)
+			}
+			resp := new(dns.Msg)
+			resp.Answer = zones[tc.signer].sign(t, []dns.RR{a})
+			_, err = v.validate(context.Background(), resp, "192.0.2.53:53")
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("validate: err = %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package dns
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// randomLabelLen is the number of random bytes in a generated subdomain label.
+const randomLabelLen = 8
+
+// loadDomains reads one domain per line from path, skipping blank lines and
+// comments.
+func loadDomains(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open domains file: %w", err)
+	}
+	defer f.Close()
+
+	var domains []string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		domain := strings.TrimSpace(scanner.Text())
+		if domain == "" || strings.HasPrefix(domain, "#") {
+			continue
+		}
+		if _, ok := dns.IsDomainName(domain); !ok {
+			return nil, fmt.Errorf("domains file %s line %d: invalid domain %q", path, line, domain)
+		}
+		domains = append(domains, domain)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read domains file: %w", err)
+	}
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("domains file %s has no domains", path)
+	}
+	return domains, nil
+}
+
+// randomSubdomain returns domain prefixed with a label no resolver can have
+// cached.
+func randomSubdomain(domain string) string {
+	b := make([]byte, randomLabelLen)
+	// crypto/rand.Read never returns an error on supported platforms.
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b) + "." + domain
+}
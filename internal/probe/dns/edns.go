@@ -0,0 +1,152 @@
+package dns
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/miekg/dns"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+)
+
+// clientCookieLen is the length of the client part of a DNS cookie (RFC 7873).
+const clientCookieLen = 8
+
+type ednsOptions struct {
+	udpSize uint16
+	subnet  *dns.EDNS0_SUBNET
+	nsid    bool
+	// cookieSecret derives a different client cookie for every server, as
+	// RFC 7873 section 4.1 recommends, so servers cannot link our queries.
+	cookieSecret []byte
+
+	mu sync.Mutex
+	// serverCookies holds the server cookie last returned by each server, as
+	// a cookie is only valid with the server that issued it.
+	serverCookies map[string]string
+}
+
+// newEDNSOptions returns nil when the target does not configure EDNS0.
+func newEDNSOptions(cfg config.DNSEDNS) (*ednsOptions, error) {
+	if cfg.UDPSize == 0 && cfg.ClientSubnet == "" && !cfg.NSID && !cfg.Cookie {
+		return nil, nil
+	}
+
+	o := &ednsOptions{
+		udpSize: cfg.UDPSize,
+		nsid:    cfg.NSID,
+	}
+	if o.udpSize == 0 {
+		o.udpSize = dns.DefaultMsgSize
+	}
+
+	if cfg.ClientSubnet != "" {
+		prefix, err := config.ParseIPOrPrefix(cfg.ClientSubnet)
+		if err != nil {
+			return nil, err
+		}
+		family := uint16(1)
+		if prefix.Addr().Is6() {
+			family = 2
+		}
+		o.subnet = &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        family,
+			SourceNetmask: uint8(prefix.Bits()),
+			Address:       prefix.Addr().AsSlice(),
+		}
+	}
+
+	if cfg.Cookie {
+		o.cookieSecret = make([]byte, sha256.Size)
+		if _, err := rand.Read(o.cookieSecret); err != nil {
+			return nil, err
+		}
+		o.serverCookies = map[string]string{}
+	}
+
+	return o, nil
+}
+
+// clientCookie returns the hex client cookie used with server.
+func (o *ednsOptions) clientCookie(server string) string {
+	mac := hmac.New(sha256.New, o.cookieSecret)
+	mac.Write([]byte(server))
+	return hex.EncodeToString(mac.Sum(nil)[:clientCookieLen])
+}
+
+// apply adds an OPT record to a query for server. It is safe to call on a nil
+// receiver, in which case only the DO bit and the default buffer size are set.
+func (o *ednsOptions) apply(msg *dns.Msg, dnssecOK bool, server string) {
+	if o == nil {
+		msg.SetEdns0(dns.DefaultMsgSize, dnssecOK)
+		return
+	}
+
+	msg.SetEdns0(o.udpSize, dnssecOK)
+	opt := msg.IsEdns0()
+	if o.subnet != nil {
+		subnet := *o.subnet
+		opt.Option = append(opt.Option, &subnet)
+	}
+	if o.nsid {
+		opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+	}
+	if o.cookieSecret != nil {
+		o.mu.Lock()
+		cookie := o.clientCookie(server) + o.serverCookies[server]
+		o.mu.Unlock()
+		opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+	}
+}
+
+// observe remembers the cookie of the server that sent resp for the next
+// query to it and returns the NSID carried by resp, if any.
+func (o *ednsOptions) observe(resp *dns.Msg, server string) string {
+	if o == nil {
+		return ""
+	}
+	opt := resp.IsEdns0()
+	if opt == nil {
+		return ""
+	}
+
+	var nsid string
+	for _, option := range opt.Option {
+		switch v := option.(type) {
+		case *dns.EDNS0_NSID:
+			nsid = decodeNSID(v.Nsid)
+		case *dns.EDNS0_COOKIE:
+			if o.cookieSecret == nil {
+				continue
+			}
+			client := o.clientCookie(server)
+			if strings.HasPrefix(strings.ToLower(v.Cookie), client) {
+				o.mu.Lock()
+				o.serverCookies[server] = v.Cookie[len(client):]
+				o.mu.Unlock()
+			}
+		}
+	}
+	return nsid
+}
+
+// decodeNSID returns the NSID as text when it is printable and as hex
+// otherwise; servers use both conventions.
+func decodeNSID(h string) string {
+	b, err := hex.DecodeString(h)
+	if err != nil || len(b) == 0 {
+		return h
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) {
+			return h
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,58 @@
+package dns
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+)
+
+func sentCookie(t *testing.T, o *ednsOptions, server string) string {
+	t.Helper()
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	o.apply(msg, false, server)
+	for _, option := range msg.IsEdns0().Option {
+		if c, ok := option.(*dns.EDNS0_COOKIE); ok {
+			return c.Cookie
+		}
+	}
+	t.Fatalf("no cookie sent to %s", server)
+	return ""
+}
+
+func TestCookiesPerServer(t *testing.T) {
+	o, err := newEDNSOptions(config.DNSEDNS{Cookie: true})
+	if err != nil {
+		t.Fatalf("newEDNSOptions: %v", err)
+	}
+	const a, b = "10.0.0.1:53", "10.0.0.2:53"
+
+	clientA, clientB := sentCookie(t, o, a), sentCookie(t, o, b)
+	if len(clientA) != 2*clientCookieLen || len(clientB) != 2*clientCookieLen {
+		t.Fatalf("first queries should only carry a client cookie, got %q and %q", clientA, clientB)
+	}
+	if clientA == clientB {
+		t.Errorf("servers share the client cookie %q", clientA)
+	}
+
+	resp := new(dns.Msg)
+	resp.SetEdns0(dns.DefaultMsgSize, false)
+	resp.IsEdns0().Option = append(resp.IsEdns0().Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: clientA + "0102030405060708"})
+	o.observe(resp, a)
+
+	if got := sentCookie(t, o, a); got != clientA+"0102030405060708" {
+		t.Errorf("cookie to %s = %q, want its server cookie appended", a, got)
+	}
+	if got := sentCookie(t, o, b); strings.Contains(got, "0102030405060708") || got != clientB {
+		t.Errorf("cookie to %s = %q, leaks the server cookie of %s", b, got, a)
+	}
+
+	// A response from b quoting a's client cookie must not be stored.
+	o.observe(resp, b)
+	if got := sentCookie(t, o, b); got != clientB {
+		t.Errorf("cookie to %s = %q after a mismatched response", b, got)
+	}
+}
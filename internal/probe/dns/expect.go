@@ -0,0 +1,164 @@
+package dns
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+)
+
+type answerMatcher struct {
+	prefixes   []netip.Prefix
+	cnames     []string
+	mxHosts    []string
+	txt        []*regexp.Regexp
+	minAnswers int
+	maxAnswers int
+	flags      []string
+}
+
+func newAnswerMatcher(e config.DNSExpect) (*answerMatcher, error) {
+	m := &answerMatcher{
+		minAnswers: e.MinAnswers,
+		maxAnswers: e.MaxAnswers,
+	}
+	for _, ip := range e.IPs {
+		prefix, err := config.ParseIPOrPrefix(ip)
+		if err != nil {
+			return nil, err
+		}
+		m.prefixes = append(m.prefixes, prefix)
+	}
+	for _, name := range e.CNAMEs {
+		m.cnames = append(m.cnames, dns.CanonicalName(name))
+	}
+	for _, host := range e.MXHosts {
+		m.mxHosts = append(m.mxHosts, dns.CanonicalName(host))
+	}
+	for _, expr := range e.TXTRegexes {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("txt regex %q: %w", expr, err)
+		}
+		m.txt = append(m.txt, re)
+	}
+	for _, flag := range e.Flags {
+		m.flags = append(m.flags, strings.ToLower(flag))
+	}
+	return m, nil
+}
+
+func (m *answerMatcher) requiresAD() bool {
+	for _, flag := range m.flags {
+		if flag == "ad" {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns a description of the first expectation resp does not meet, or
+// nil when every expectation holds. Each configured value must be matched by
+// at least one record in the answer section.
+func (m *answerMatcher) match(resp *dns.Msg, qtype uint16) error {
+	count := 0
+	var addrs []netip.Addr
+	var cnames, mxHosts, txts []string
+	for _, rr := range resp.Answer {
+		if qtype == dns.TypeANY || rr.Header().Rrtype == qtype {
+			count++
+		}
+		switch v := rr.(type) {
+		case *dns.A:
+			if addr, ok := netip.AddrFromSlice(v.A); ok {
+				addrs = append(addrs, addr.Unmap())
+			}
+		case *dns.AAAA:
+			if addr, ok := netip.AddrFromSlice(v.AAAA); ok {
+				addrs = append(addrs, addr)
+			}
+		case *dns.CNAME:
+			cnames = append(cnames, dns.CanonicalName(v.Target))
+		case *dns.MX:
+			mxHosts = append(mxHosts, dns.CanonicalName(v.Mx))
+		case *dns.TXT:
+			txts = append(txts, strings.Join(v.Txt, ""))
+		}
+	}
+
+	if count < m.minAnswers {
+		return fmt.Errorf("got %d answers, want at least %d", count, m.minAnswers)
+	}
+	if m.maxAnswers > 0 && count > m.maxAnswers {
+		return fmt.Errorf("got %d answers, want at most %d", count, m.maxAnswers)
+	}
+
+	for _, prefix := range m.prefixes {
+		if !containsAny(prefix, addrs) {
+			return fmt.Errorf("no address answer in %s", prefix)
+		}
+	}
+	for _, name := range m.cnames {
+		if !contains(cnames, name) {
+			return fmt.Errorf("no cname answer for %s", name)
+		}
+	}
+	for _, host := range m.mxHosts {
+		if !contains(mxHosts, host) {
+			return fmt.Errorf("no mx answer for %s", host)
+		}
+	}
+	for _, re := range m.txt {
+		if !matchesAny(re, txts) {
+			return fmt.Errorf("no txt answer matching %q", re)
+		}
+	}
+
+	for _, flag := range m.flags {
+		var set bool
+		switch flag {
+		case "aa":
+			set = resp.Authoritative
+		case "ad":
+			set = resp.AuthenticatedData
+		case "ra":
+			set = resp.RecursionAvailable
+		}
+		if !set {
+			return fmt.Errorf("flag %s not set", strings.ToUpper(flag))
+		}
+	}
+
+	return nil
+}
+
+func containsAny(prefix netip.Prefix, addrs []netip.Addr) bool {
+	for _, addr := range addrs {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(re *regexp.Regexp, values []string) bool {
+	for _, v := range values {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
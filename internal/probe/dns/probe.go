@@ -3,10 +3,12 @@ package dns
 import (
 	"context"
 	"errors"
-	"fmt"
 	"net"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
@@ -20,20 +22,59 @@ import (
 
 type Probe struct {
 	target   config.DNSTarget
-	client   *dns.Client
+	client   exchanger
 	metrics  *metrics.DNS
 	interval time.Duration
-	server   string
+	servers  []string
+	domain   string
+	domains  []string
+	next     atomic.Uint64
+	expect   *answerMatcher
+	dnssec   *dnssecValidator
+	edns     *ednsOptions
+	// fullTTLs holds the highest TTL each server returned for each name,
+	// which is the TTL of a freshly fetched answer.
+	fullTTLs sync.Map
 }
 
-func New(target config.DNSTarget, m *metrics.DNS) *Probe {
-	return &Probe{
+func New(target config.DNSTarget, m *metrics.DNS) (*Probe, error) {
+	expect, err := newAnswerMatcher(target.Expect)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newExchanger(target)
+	if err != nil {
+		return nil, err
+	}
+	edns, err := newEDNSOptions(target.EDNS)
+	if err != nil {
+		return nil, err
+	}
+	p := &Probe{
 		target:   target,
-		client:   &dns.Client{Timeout: target.Timeout},
+		client:   client,
 		metrics:  m,
 		interval: probe.IntervalFromRPS(target.RPS),
-		server:   net.JoinHostPort(target.ServerIP, strconv.Itoa(target.ServerPort)),
+		servers:  target.Servers,
+		domain:   target.Domain,
+		domains:  []string{target.Domain},
+		expect:   expect,
+		edns:     edns,
+	}
+	if target.DomainsFile != "" {
+		p.domain = filepath.Base(target.DomainsFile)
+		p.domains, err = loadDomains(target.DomainsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if target.DNSSEC.Enabled {
+		p.dnssec, err = newDNSSECValidator(target.DNSSEC, client)
+		if err != nil {
+			return nil, err
+		}
 	}
+	return p, nil
 }
 
 func (p *Probe) Run(ctx context.Context) error {
@@ -45,24 +86,155 @@ func (p *Probe) Run(ctx context.Context) error {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-ticker.C:
-			go p.probeOnce(ctx)
+			// Every server gets the same domain each tick, so all of them
+			// rotate through the whole list whatever their number.
+			domain := p.nextDomain()
+			for _, server := range p.servers {
+				go p.probeOnce(ctx, server, domain)
+			}
 		}
 	}
 }
 
-func (p *Probe) probeOnce(ctx context.Context) {
-	stats := p.sendRequest(ctx)
+func (p *Probe) probeOnce(ctx context.Context, server, domain string) {
+	stats := p.sendRequest(ctx, server, domain)
 	labels := prometheus.Labels{
-		"domain":      p.target.Domain,
+		"domain":      p.domain,
 		"rcode":       stats.rcode,
 		"rcode_value": strconv.Itoa(stats.rcodeValue),
 		"result":      stats.result,
 		"name":        p.target.Name,
-		"server":      p.server,
+		"server":      server,
+		"transport":   stats.transport,
 	}
 
 	p.metrics.Requests.With(labels).Inc()
 	p.metrics.Durations.With(labels).Observe(stats.responseTime)
+
+	if stats.result == "success" {
+		if cache, ok := p.cacheStatus(server, stats.qname, stats.resp); ok {
+			p.metrics.Cache.With(prometheus.Labels{
+				"name":   p.target.Name,
+				"domain": p.domain,
+				"server": server,
+				"cache":  cache,
+			}).Observe(stats.responseTime)
+		}
+	}
+
+	if stats.resp != nil {
+		p.observeResponse(stats.resp, server, stats.transport, stats.truncated)
+	}
+
+	if stats.nsid != "" {
+		p.metrics.NSIDResponses.With(prometheus.Labels{
+			"name":   p.target.Name,
+			"server": server,
+			"nsid":   stats.nsid,
+		}).Inc()
+	}
+
+	for zone, expiry := range stats.dnssecExpiry {
+		p.metrics.DNSSECExpiry.With(prometheus.Labels{
+			"name":   p.target.Name,
+			"domain": p.domain,
+			"server": server,
+			"zone":   zone,
+		}).Set(float64(expiry.Unix()))
+	}
+}
+
+func (p *Probe) observeResponse(resp *dns.Msg, server, transport string, truncated bool) {
+	labels := prometheus.Labels{
+		"name":      p.target.Name,
+		"domain":    p.domain,
+		"server":    server,
+		"transport": transport,
+	}
+
+	if ttl, ok := minTTL(resp.Answer); ok {
+		p.metrics.MinTTL.With(labels).Set(float64(ttl))
+	}
+
+	sections := map[string]int{
+		"answer":     len(resp.Answer),
+		"authority":  len(resp.Ns),
+		"additional": len(resp.Extra),
+	}
+	for section, count := range sections {
+		p.metrics.RecordCount.With(prometheus.Labels{
+			"name":      p.target.Name,
+			"domain":    p.domain,
+			"server":    server,
+			"transport": transport,
+			"section":   section,
+		}).Set(float64(count))
+	}
+
+	// The wire bytes aren't exposed by the client, so re-encode the message
+	// the way servers send it.
+	compress := resp.Compress
+	resp.Compress = true
+	p.metrics.ResponseSize.With(labels).Observe(float64(resp.Len()))
+	resp.Compress = compress
+
+	// The tcp retry of a truncated udp answer is never truncated itself.
+	tc := 0.0
+	if truncated || resp.Truncated {
+		tc = 1
+	}
+	p.metrics.Truncated.With(labels).Set(tc)
+}
+
+// cacheStatus tells whether resp came from the resolver's cache. A cached
+// answer has its TTL counting down, while a freshly fetched one carries the
+// full TTL, the highest seen so far for the name. The first answer for a name
+// has nothing to compare with and is left unclassified.
+func (p *Probe) cacheStatus(server, qname string, resp *dns.Msg) (string, bool) {
+	if p.target.RandomSubdomain {
+		return "miss", true
+	}
+	if resp == nil {
+		return "", false
+	}
+	ttl, ok := minTTL(resp.Answer)
+	if !ok {
+		// Negative answers are cached for the SOA TTL in the authority
+		// section.
+		ttl, ok = minTTL(resp.Ns)
+	}
+	if !ok {
+		return "", false
+	}
+
+	key := server + " " + qname + " " + dns.TypeToString[p.target.QType]
+	prev, seen := p.fullTTLs.Load(key)
+	if !seen || ttl > prev.(uint32) {
+		p.fullTTLs.Store(key, ttl)
+	}
+	switch {
+	case !seen:
+		return "", false
+	case ttl < prev.(uint32):
+		return "hit", true
+	default:
+		return "miss", true
+	}
+}
+
+func minTTL(rrs []dns.RR) (uint32, bool) {
+	var ttl uint32
+	found := false
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		if !found || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+			found = true
+		}
+	}
+	return ttl, found
 }
 
 type dnsProbeStats struct {
@@ -70,77 +242,146 @@ type dnsProbeStats struct {
 	rcode        string
 	rcodeValue   int
 	result       string
+	transport    string
+	truncated    bool
+	nsid         string
+	qname        string
+	resp         *dns.Msg
+	dnssecExpiry map[string]time.Time
 }
 
-func (p *Probe) sendRequest(ctx context.Context) dnsProbeStats {
-	msg := new(dns.Msg)
-	recordType, err := p.parseRecordType()
-	if err != nil {
-		klog.Warning(err)
-		return dnsProbeStats{
-			rcodeValue: -1,
-			result:     "error",
-		}
+func (p *Probe) sendRequest(ctx context.Context, server, domain string) dnsProbeStats {
+	stats := dnsProbeStats{
+		rcodeValue: -1,
+		transport:  p.target.Transport,
 	}
-	msg.SetQuestion(dns.Fqdn(p.target.Domain), recordType)
-	msg.RecursionDesired = true
 
-	resp, rtt, err := p.client.ExchangeContext(ctx, msg, p.server)
-	responseTime := float64(rtt) / float64(time.Second)
-	if err != nil {
-		return dnsProbeStats{
-			rcodeValue:   -1,
-			result:       classifyDNSError(err),
-			responseTime: responseTime,
+	recordType := p.target.QType
+	// A random label per server keeps resolvers that share a cache from
+	// answering each other's misses.
+	if p.target.RandomSubdomain {
+		domain = randomSubdomain(domain)
+	}
+	names := searchNames(domain, p.target.SearchDomains, p.target.NDots)
+	var resp *dns.Msg
+	var rtt time.Duration
+	var err error
+	for i, name := range names {
+		var nameRTT time.Duration
+		var answeredVia via
+		resp, nameRTT, answeredVia, err = p.query(ctx, server, name, recordType)
+		rtt += nameRTT
+		stats.transport = answeredVia.transport
+		stats.truncated = answeredVia.truncated
+		stats.qname = name
+		if err != nil || i == len(names)-1 || !isNegative(resp) {
+			break
 		}
+		klog.V(4).Infof("dns probe %q: no answer for %s from %s, trying next search domain", p.target.Name, name, server)
+	}
+	stats.responseTime = float64(rtt) / float64(time.Second)
+	if err != nil {
+		stats.result = classifyDNSError(err)
+		return stats
+	}
+
+	stats.resp = resp
+	stats.rcodeValue = resp.Rcode
+	stats.rcode = dns.RcodeToString[resp.Rcode]
+	stats.nsid = p.edns.observe(resp, server)
+
+	// Random subdomains normally don't exist, so NXDOMAIN is the expected
+	// answer to a query that went all the way to the authoritative servers.
+	if p.target.RandomSubdomain && resp.Rcode == dns.RcodeNameError {
+		stats.result = "success"
+		return stats
 	}
 	if resp.Rcode != dns.RcodeSuccess {
-		return dnsProbeStats{
-			rcodeValue: resp.Rcode,
-			rcode:      dns.RcodeToString[resp.Rcode],
-			result:     "error",
+		stats.result = "error"
+		return stats
+	}
+	if err := p.expect.match(resp, recordType); err != nil {
+		klog.V(4).Infof("dns probe %q: answer mismatch from %s: %v", p.target.Name, server, err)
+		stats.result = "answer_mismatch"
+		return stats
+	}
+
+	stats.result = "success"
+	if p.dnssec != nil && len(resp.Answer) > 0 {
+		expiry, err := p.dnssec.validate(ctx, resp, server)
+		stats.dnssecExpiry = expiry
+		if err != nil {
+			klog.V(4).Infof("dns probe %q: dnssec validation failed via %s: %v", p.target.Name, server, err)
+			stats.result = "dnssec_invalid"
 		}
 	}
-	return dnsProbeStats{
-		responseTime: responseTime,
-		rcodeValue:   resp.Rcode,
-		rcode:        dns.RcodeToString[resp.Rcode],
-		result:       "success",
+	return stats
+}
+
+// nextDomain rotates through the configured domains.
+func (p *Probe) nextDomain() string {
+	return p.domains[(p.next.Add(1)-1)%uint64(len(p.domains))]
+}
+
+// query sends a single question to server. The returned via names the
+// transport that answered, tcp when a truncated udp response was retried,
+// and whether the udp response was truncated.
+func (p *Probe) query(ctx context.Context, server, name string, qtype uint16) (*dns.Msg, time.Duration, via, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.RecursionDesired = true
+	msg.AuthenticatedData = p.expect.requiresAD()
+	if p.edns != nil || p.dnssec != nil {
+		p.edns.apply(msg, p.dnssec != nil, server)
+	}
+	if p.dnssec != nil {
+		// Keep the resolver from filtering bogus data; validation happens
+		// here so failures can be told apart from SERVFAIL.
+		msg.CheckingDisabled = true
+	}
+
+	if fallback, ok := p.client.(*fallbackExchanger); ok {
+		return fallback.exchangeVia(ctx, msg, server)
 	}
+	resp, rtt, err := p.client.exchange(ctx, msg, server)
+	return resp, rtt, via{transport: p.target.Transport, truncated: err == nil && resp.Truncated}, err
 }
 
-func (p *Probe) parseRecordType() (uint16, error) {
-	switch strings.ToUpper(p.target.RecordType) {
-	case "A":
-		return dns.TypeA, nil
-	case "AAAA":
-		return dns.TypeAAAA, nil
-	case "ANY":
-		return dns.TypeANY, nil
-	case "CNAME":
-		return dns.TypeCNAME, nil
-	case "MX":
-		return dns.TypeMX, nil
-	case "NS":
-		return dns.TypeNS, nil
-	case "PTR":
-		return dns.TypePTR, nil
-	case "SOA":
-		return dns.TypeSOA, nil
-	case "SPF":
-		return dns.TypeSPF, nil
-	case "SRV":
-		return dns.TypeSRV, nil
-	case "TXT":
-		return dns.TypeTXT, nil
-	default:
-		return 0, fmt.Errorf("record type %s not recognized", p.target.RecordType)
+// searchNames returns the names to try for domain in order, following the
+// resolv.conf(5) rules: names with at least ndots dots are tried as-is
+// first, others only after every search domain. Fully qualified names and
+// targets without search domains are queried as-is only.
+func searchNames(domain string, search []string, ndots int) []string {
+	if dns.IsFqdn(domain) || len(search) == 0 {
+		return []string{dns.Fqdn(domain)}
+	}
+
+	var names []string
+	absoluteFirst := strings.Count(domain, ".") >= ndots
+	if absoluteFirst {
+		names = append(names, dns.Fqdn(domain))
+	}
+	for _, suffix := range search {
+		names = append(names, dns.Fqdn(domain+"."+strings.Trim(suffix, ".")))
+	}
+	if !absoluteFirst {
+		names = append(names, dns.Fqdn(domain))
 	}
+	return names
+}
+
+// isNegative reports whether resp says the name doesn't exist or has no
+// records, which makes stub resolvers move on to the next search domain.
+func isNegative(resp *dns.Msg) bool {
+	return resp.Rcode == dns.RcodeNameError || (resp.Rcode == dns.RcodeSuccess && len(resp.Answer) == 0)
 }
 
 func classifyDNSError(err error) string {
-	var opErr *net.OpError
-	if errors.As(err, &opErr) && opErr.Timeout() {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
 		return "timeout"
 	}
 	return "error"
@@ -0,0 +1,107 @@
+package dns
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/metrics"
+)
+
+func answerWithTTL(ttl uint32) *dns.Msg {
+	rr, err := dns.NewRR("example.com. 300 IN A 192.0.2.1")
+	if err != nil {
+		panic(err)
+	}
+	rr.Header().Ttl = ttl
+	return &dns.Msg{Answer: []dns.RR{rr}}
+}
+
+func TestCacheStatus(t *testing.T) {
+	p := &Probe{target: config.DNSTarget{QType: dns.TypeA}}
+	const server, name = "10.0.0.1:53", "example.com."
+
+	steps := []struct {
+		ttl        uint32
+		cache      string
+		classified bool
+	}{
+		{ttl: 280}, // nothing to compare with yet
+		{ttl: 275, cache: "hit", classified: true},
+		{ttl: 300, cache: "miss", classified: true}, // refetched with the full TTL
+		{ttl: 299, cache: "hit", classified: true},
+		{ttl: 300, cache: "miss", classified: true},
+	}
+	for i, step := range steps {
+		cache, ok := p.cacheStatus(server, name, answerWithTTL(step.ttl))
+		if ok != step.classified || cache != step.cache {
+			t.Errorf("step %d (ttl %d): got %q, %t, want %q, %t", i, step.ttl, cache, ok, step.cache, step.classified)
+		}
+	}
+
+	// Every server keeps its own reference TTL.
+	if _, ok := p.cacheStatus("10.0.0.2:53", name, answerWithTTL(250)); ok {
+		t.Errorf("first answer from another server was classified")
+	}
+
+	p.target.RandomSubdomain = true
+	if cache, ok := p.cacheStatus(server, "x1y2.example.com.", nil); !ok || cache != "miss" {
+		t.Errorf("random subdomain: got %q, %t, want miss", cache, ok)
+	}
+}
+
+// recordingExchanger answers every question and remembers which names each
+// server was asked.
+type recordingExchanger struct {
+	mu    sync.Mutex
+	asked map[string]map[string]bool
+}
+
+func (e *recordingExchanger) exchange(_ context.Context, msg *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	e.mu.Lock()
+	if e.asked[server] == nil {
+		e.asked[server] = make(map[string]bool)
+	}
+	e.asked[server][msg.Question[0].Name] = true
+	e.mu.Unlock()
+	resp := new(dns.Msg)
+	resp.SetReply(msg)
+	return resp, 0, nil
+}
+
+func TestRunRotatesDomainsPerServer(t *testing.T) {
+	p, err := New(config.DNSTarget{
+		Name:      "rotate",
+		Domain:    "a.example.",
+		Servers:   []string{"10.0.0.1:53", "10.0.0.2:53"},
+		RPS:       100,
+		Timeout:   time.Second,
+		Transport: "udp",
+		QType:     dns.TypeA,
+	}, metrics.NewDNS(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatalf("new probe: %v", err)
+	}
+	client := &recordingExchanger{asked: make(map[string]map[string]bool)}
+	p.client = client
+	p.domains = []string{"a.example.", "b.example."}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_ = p.Run(ctx)
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for _, server := range p.servers {
+		for _, domain := range p.domains {
+			if !client.asked[server][domain] {
+				t.Errorf("server %s was never asked %s", server, domain)
+			}
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	klog "k8s.io/klog/v2"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+)
+
+// exchanger sends a single query to a server over one of the supported
+// transports and returns the response together with the round-trip time.
+type exchanger interface {
+	exchange(ctx context.Context, msg *dns.Msg, server string) (*dns.Msg, time.Duration, error)
+}
+
+func newExchanger(target config.DNSTarget) (exchanger, error) {
+	switch target.Transport {
+	case config.DNSTransportUDP:
+		if target.DisableTCPFallback {
+			return &clientExchanger{client: &dns.Client{Net: "udp", Timeout: target.Timeout}}, nil
+		}
+		// Retry truncated responses over tcp, like stub resolvers do.
+		return newFallbackExchanger(target.Timeout), nil
+	case config.DNSTransportTCP:
+		return &clientExchanger{client: &dns.Client{Net: "tcp", Timeout: target.Timeout}}, nil
+	case config.DNSTransportDoT:
+		tlsConfig, err := buildTLSConfig(target)
+		if err != nil {
+			return nil, err
+		}
+		return &clientExchanger{client: &dns.Client{Net: "tcp-tls", Timeout: target.Timeout, TLSConfig: tlsConfig}}, nil
+	case config.DNSTransportDoH:
+		tlsConfig, err := buildTLSConfig(target)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		return &dohExchanger{
+			client:     &http.Client{Timeout: target.Timeout, Transport: transport},
+			path:       target.DoHPath,
+			serverName: target.TLSServerName,
+		}, nil
+	case config.DNSTransportDoQ:
+		tlsConfig, err := buildTLSConfig(target)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.NextProtos = []string{"doq"}
+		return &doqExchanger{tlsConfig: tlsConfig, timeout: target.Timeout}, nil
+	default:
+		return nil, fmt.Errorf("unknown transport %q", target.Transport)
+	}
+}
+
+func buildTLSConfig(target config.DNSTarget) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         target.TLSServerName,
+		InsecureSkipVerify: target.TLSSkipVerify,
+	}
+	if target.CAFile != "" {
+		pem, err := os.ReadFile(target.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca file %s: no certificates found", target.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+type clientExchanger struct {
+	client *dns.Client
+}
+
+func (e *clientExchanger) exchange(ctx context.Context, msg *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	return e.client.ExchangeContext(ctx, msg, server)
+}
+
+// fallbackExchanger queries over udp and retries truncated responses over
+// tcp. The round-trip time covers both attempts.
+type fallbackExchanger struct {
+	udp exchanger
+	tcp exchanger
+}
+
+func newFallbackExchanger(timeout time.Duration) *fallbackExchanger {
+	return &fallbackExchanger{
+		udp: &clientExchanger{client: &dns.Client{Net: "udp", Timeout: timeout}},
+		tcp: &clientExchanger{client: &dns.Client{Net: "tcp", Timeout: timeout}},
+	}
+}
+
+func (e *fallbackExchanger) exchange(ctx context.Context, msg *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	resp, rtt, _, err := e.exchangeVia(ctx, msg, server)
+	return resp, rtt, err
+}
+
+// via tells how a response was obtained.
+type via struct {
+	transport string
+	// truncated is set when the udp answer had the TC flag, also when it was
+	// then retried over tcp.
+	truncated bool
+}
+
+// exchangeVia is exchange that also tells how the response was obtained.
+func (e *fallbackExchanger) exchangeVia(ctx context.Context, msg *dns.Msg, server string) (*dns.Msg, time.Duration, via, error) {
+	resp, rtt, err := e.udp.exchange(ctx, msg, server)
+	if err != nil || !resp.Truncated {
+		return resp, rtt, via{transport: config.DNSTransportUDP, truncated: err == nil && resp.Truncated}, err
+	}
+	klog.V(4).Infof("truncated dns response from %s, retrying over tcp", server)
+	resp, tcpRTT, err := e.tcp.exchange(ctx, msg, server)
+	return resp, rtt + tcpRTT, via{transport: config.DNSTransportTCP, truncated: true}, err
+}
+
+// dohExchanger implements RFC 8484 using POST requests.
+type dohExchanger struct {
+	client     *http.Client
+	path       string
+	serverName string
+}
+
+func (e *dohExchanger) exchange(ctx context.Context, msg *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	query := msg.Copy()
+	// The message ID is meant to be 0 so that responses are cacheable.
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	u := url.URL{Scheme: "https", Host: server, Path: e.path}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(packed))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	if e.serverName != "" {
+		req.Host = e.serverName
+	}
+
+	start := time.Now()
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, time.Since(start), err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			klog.V(4).Infof("close doh response body failed: %v", err)
+		}
+	}()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	rtt := time.Since(start)
+	if err != nil {
+		return nil, rtt, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, rtt, fmt.Errorf("doh server returned status %d", resp.StatusCode)
+	}
+
+	answer := new(dns.Msg)
+	if err := answer.Unpack(body); err != nil {
+		return nil, rtt, err
+	}
+	answer.Id = msg.Id
+	return answer, rtt, nil
+}
+
+// doqExchanger implements RFC 9250. Each query opens its own connection so
+// the measured time includes the handshake, like the other transports.
+type doqExchanger struct {
+	tlsConfig *tls.Config
+	timeout   time.Duration
+}
+
+func (e *doqExchanger) exchange(ctx context.Context, msg *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	query := msg.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := quic.DialAddr(ctx, server, e.tlsConfig, nil)
+	if err != nil {
+		return nil, time.Since(start), err
+	}
+	defer func() {
+		if err := conn.CloseWithError(0, ""); err != nil {
+			klog.V(4).Infof("close doq connection failed: %v", err)
+		}
+	}()
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, time.Since(start), err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := stream.SetDeadline(deadline); err != nil {
+			klog.V(4).Infof("set doq stream deadline failed: %v", err)
+		}
+	}
+
+	buf := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(buf, uint16(len(packed)))
+	copy(buf[2:], packed)
+	if _, err := stream.Write(buf); err != nil {
+		return nil, time.Since(start), err
+	}
+	// Closing the send side signals the end of the query to the server.
+	if err := stream.Close(); err != nil {
+		return nil, time.Since(start), err
+	}
+
+	var length uint16
+	if err := binary.Read(stream, binary.BigEndian, &length); err != nil {
+		return nil, time.Since(start), err
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(stream, body); err != nil {
+		return nil, time.Since(start), err
+	}
+	rtt := time.Since(start)
+
+	answer := new(dns.Msg)
+	if err := answer.Unpack(body); err != nil {
+		return nil, rtt, err
+	}
+	answer.Id = msg.Id
+	return answer, rtt, nil
+}
@@ -0,0 +1,103 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+)
+
+// truncatingServer answers every query over tcp and only with the TC bit set
+// over udp, on the same port.
+func truncatingServer(t *testing.T) string {
+	t.Helper()
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		if _, udp := w.RemoteAddr().(*net.UDPAddr); udp {
+			resp.Truncated = true
+		} else {
+			rr, _ := dns.NewRR(r.Question[0].Name + " 300 IN A 192.0.2.1")
+			resp.Answer = append(resp.Answer, rr)
+		}
+		_ = w.WriteMsg(resp)
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("listen tcp: %v", err)
+	}
+	for _, srv := range []*dns.Server{{PacketConn: pc, Handler: handler}, {Listener: l, Handler: handler}} {
+		started := make(chan struct{})
+		srv.NotifyStartedFunc = func() { close(started) }
+		go func(srv *dns.Server) { _ = srv.ActivateAndServe() }(srv)
+		<-started
+		t.Cleanup(func() { _ = srv.Shutdown() })
+	}
+	return pc.LocalAddr().String()
+}
+
+func TestTCPFallback(t *testing.T) {
+	server := truncatingServer(t)
+	ctx := context.Background()
+
+	p, err := New(config.DNSTarget{
+		Name:      "fallback",
+		Domain:    "example.com",
+		Transport: config.DNSTransportUDP,
+		QType:     dns.TypeA,
+		Timeout:   time.Second,
+		Servers:   []string{server},
+	}, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	resp, rtt, answeredVia, err := p.query(ctx, server, "example.com.", dns.TypeA)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if resp.Truncated || len(resp.Answer) != 1 || answeredVia.transport != config.DNSTransportTCP || rtt <= 0 {
+		t.Errorf("got truncated=%t answers=%d transport=%s rtt=%s, want the tcp answer", resp.Truncated, len(resp.Answer), answeredVia.transport, rtt)
+	}
+	if !answeredVia.truncated {
+		t.Errorf("the udp answer retried over tcp was not reported as truncated")
+	}
+
+	// The DNSSEC validator goes through the same exchanger.
+	v, err := newDNSSECValidator(config.DNSSEC{}, p.client)
+	if err != nil {
+		t.Fatalf("newDNSSECValidator: %v", err)
+	}
+	rrs, _, err := v.query(ctx, server, "example.com.", dns.TypeA)
+	if err != nil || len(rrs) != 1 {
+		t.Errorf("dnssec query: got %d records, %v, want the tcp answer", len(rrs), err)
+	}
+
+	p, err = New(config.DNSTarget{
+		Name:               "no-fallback",
+		Domain:             "example.com",
+		Transport:          config.DNSTransportUDP,
+		QType:              dns.TypeA,
+		Timeout:            time.Second,
+		Servers:            []string{server},
+		DisableTCPFallback: true,
+	}, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	resp, _, answeredVia, err = p.query(ctx, server, "example.com.", dns.TypeA)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if !resp.Truncated || !answeredVia.truncated || answeredVia.transport != config.DNSTransportUDP {
+		t.Errorf("got truncated=%t transport=%s, want the truncated udp answer", resp.Truncated, answeredVia.transport)
+	}
+}
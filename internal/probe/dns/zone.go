@@ -0,0 +1,287 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	klog "k8s.io/klog/v2"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/metrics"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/probe"
+)
+
+// ZoneProbe discovers the NS set of a zone and compares the SOA serial served
+// by each authoritative nameserver.
+type ZoneProbe struct {
+	target   config.DNSZoneTarget
+	client   exchanger
+	metrics  *metrics.DNS
+	interval time.Duration
+	zone     string
+
+	mu     sync.Mutex
+	serial map[zoneServer]struct{}
+}
+
+type zoneServer struct {
+	nameserver string
+	server     string
+}
+
+type soaResult struct {
+	zoneServer
+	rtt    time.Duration
+	result string
+	serial uint32
+	lame   string
+}
+
+func NewZone(target config.DNSZoneTarget, m *metrics.DNS) *ZoneProbe {
+	return &ZoneProbe{
+		target:   target,
+		client:   newFallbackExchanger(target.Timeout),
+		metrics:  m,
+		interval: probe.IntervalFromRPS(target.RPS),
+		zone:     dns.Fqdn(target.Zone),
+		serial:   make(map[zoneServer]struct{}),
+	}
+}
+
+func (p *ZoneProbe) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			go p.probeOnce(ctx)
+		}
+	}
+}
+
+func (p *ZoneProbe) probeOnce(ctx context.Context) {
+	servers, err := p.discover(ctx)
+	if err != nil {
+		klog.V(4).Infof("dns zone probe %q: discover nameservers via %s: %v", p.target.Name, p.target.Resolver, err)
+		p.record(soaResult{
+			zoneServer: zoneServer{server: p.target.Resolver},
+			result:     "ns_lookup_failed",
+		})
+		return
+	}
+
+	results := make([]soaResult, len(servers))
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Add(1)
+		go func(i int, s zoneServer) {
+			defer wg.Done()
+			results[i] = p.querySOA(ctx, s)
+		}(i, s)
+	}
+	wg.Wait()
+
+	var newest uint32
+	found := false
+	for _, r := range results {
+		p.record(r)
+		if r.result != "success" {
+			continue
+		}
+		if !found || serialNewer(r.serial, newest) {
+			newest = r.serial
+			found = true
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	current := make(map[zoneServer]struct{}, len(results))
+	for _, r := range results {
+		if r.result != "success" {
+			continue
+		}
+		labels := p.serverLabels(r.zoneServer)
+		p.metrics.ZoneSerial.With(labels).Set(float64(r.serial))
+		p.metrics.ZoneSerialDrift.With(labels).Set(float64(newest - r.serial))
+		current[r.zoneServer] = struct{}{}
+	}
+	// Servers that left the NS set or stopped answering no longer have a
+	// serial worth comparing.
+	for s := range p.serial {
+		if _, ok := current[s]; !ok {
+			p.metrics.ZoneSerial.Delete(p.serverLabels(s))
+			p.metrics.ZoneSerialDrift.Delete(p.serverLabels(s))
+		}
+	}
+	p.serial = current
+}
+
+func (p *ZoneProbe) record(r soaResult) {
+	labels := prometheus.Labels{
+		"name":       p.target.Name,
+		"zone":       p.zone,
+		"nameserver": r.nameserver,
+		"server":     r.server,
+		"result":     r.result,
+	}
+	p.metrics.ZoneRequests.With(labels).Inc()
+	p.metrics.ZoneDurations.With(labels).Observe(float64(r.rtt) / float64(time.Second))
+
+	if r.lame != "" {
+		p.metrics.ZoneLameDelegations.With(prometheus.Labels{
+			"name":       p.target.Name,
+			"zone":       p.zone,
+			"nameserver": r.nameserver,
+			"server":     r.server,
+			"reason":     r.lame,
+		}).Inc()
+	}
+}
+
+func (p *ZoneProbe) serverLabels(s zoneServer) prometheus.Labels {
+	return prometheus.Labels{
+		"name":       p.target.Name,
+		"zone":       p.zone,
+		"nameserver": s.nameserver,
+		"server":     s.server,
+	}
+}
+
+// discover returns every address of every nameserver in the NS set of the
+// zone, using glue records from the resolver's response when present.
+func (p *ZoneProbe) discover(ctx context.Context) ([]zoneServer, error) {
+	resp, err := p.lookup(ctx, p.zone, dns.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+
+	glue := make(map[string][]string)
+	for _, rr := range resp.Extra {
+		switch v := rr.(type) {
+		case *dns.A:
+			glue[dns.CanonicalName(v.Hdr.Name)] = append(glue[dns.CanonicalName(v.Hdr.Name)], v.A.String())
+		case *dns.AAAA:
+			glue[dns.CanonicalName(v.Hdr.Name)] = append(glue[dns.CanonicalName(v.Hdr.Name)], v.AAAA.String())
+		}
+	}
+
+	var nameservers []string
+	for _, rr := range resp.Answer {
+		if ns, ok := rr.(*dns.NS); ok && dns.CanonicalName(ns.Hdr.Name) == dns.CanonicalName(p.zone) {
+			nameservers = append(nameservers, dns.CanonicalName(ns.Ns))
+		}
+	}
+	if len(nameservers) == 0 {
+		return nil, fmt.Errorf("no NS records for %s", p.zone)
+	}
+	sort.Strings(nameservers)
+	p.metrics.ZoneNameservers.With(prometheus.Labels{"name": p.target.Name, "zone": p.zone}).Set(float64(len(nameservers)))
+
+	port := strconv.Itoa(p.target.Port)
+	var servers []zoneServer
+	for _, ns := range nameservers {
+		addrs, ok := glue[ns]
+		if !ok {
+			addrs = p.resolve(ctx, ns)
+		}
+		if len(addrs) == 0 {
+			klog.V(4).Infof("dns zone probe %q: nameserver %s has no address", p.target.Name, ns)
+			p.record(soaResult{zoneServer: zoneServer{nameserver: ns}, result: "unresolvable", lame: "unresolvable"})
+			continue
+		}
+		for _, addr := range addrs {
+			servers = append(servers, zoneServer{nameserver: ns, server: net.JoinHostPort(addr, port)})
+		}
+	}
+	return servers, nil
+}
+
+func (p *ZoneProbe) resolve(ctx context.Context, host string) []string {
+	var addrs []string
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		resp, err := p.lookup(ctx, host, qtype)
+		if err != nil {
+			klog.V(4).Infof("dns zone probe %q: resolve %s %s: %v", p.target.Name, host, dns.TypeToString[qtype], err)
+			continue
+		}
+		for _, rr := range resp.Answer {
+			switch v := rr.(type) {
+			case *dns.A:
+				addrs = append(addrs, v.A.String())
+			case *dns.AAAA:
+				addrs = append(addrs, v.AAAA.String())
+			}
+		}
+	}
+	return addrs
+}
+
+func (p *ZoneProbe) lookup(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.RecursionDesired = true
+
+	resp, _, err := p.client.exchange(ctx, msg, p.target.Resolver)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("rcode %s", dns.RcodeToString[resp.Rcode])
+	}
+	return resp, nil
+}
+
+// querySOA asks a single authoritative server for the zone's SOA without
+// recursion. Anything short of an authoritative SOA answer is a lame
+// delegation; timeouts and network errors are reported as results only.
+func (p *ZoneProbe) querySOA(ctx context.Context, s zoneServer) soaResult {
+	r := soaResult{zoneServer: s}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(p.zone, dns.TypeSOA)
+	msg.RecursionDesired = false
+
+	resp, rtt, err := p.client.exchange(ctx, msg, s.server)
+	r.rtt = rtt
+	if err != nil {
+		r.result = classifyDNSError(err)
+		return r
+	}
+
+	if resp.Rcode != dns.RcodeSuccess {
+		r.result = "lame"
+		r.lame = "rcode_" + dns.RcodeToString[resp.Rcode]
+		return r
+	}
+	if !resp.Authoritative {
+		r.result = "lame"
+		r.lame = "not_authoritative"
+		return r
+	}
+	for _, rr := range resp.Answer {
+		if soa, ok := rr.(*dns.SOA); ok && dns.CanonicalName(soa.Hdr.Name) == dns.CanonicalName(p.zone) {
+			r.result = "success"
+			r.serial = soa.Serial
+			return r
+		}
+	}
+	r.result = "lame"
+	r.lame = "no_soa"
+	return r
+}
+
+// serialNewer compares SOA serials using RFC 1982 serial number arithmetic.
+func serialNewer(a, b uint32) bool {
+	return a != b && int32(a-b) > 0
+}
@@ -0,0 +1,229 @@
+package elasticsearch
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	klog "k8s.io/klog/v2"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/metrics"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/probe"
+)
+
+var statuses = []string{"green", "yellow", "red"}
+
+type Probe struct {
+	target    config.ElasticsearchTarget
+	client    *http.Client
+	healthURL string
+	metrics   *metrics.Elasticsearch
+	interval  time.Duration
+}
+
+func New(target config.ElasticsearchTarget, m *metrics.Elasticsearch) (*Probe, error) {
+	client, err := buildClient(target)
+	if err != nil {
+		return nil, err
+	}
+	healthURL, err := url.JoinPath(target.URL, "_cluster", "health")
+	if err != nil {
+		return nil, fmt.Errorf("build health url: %w", err)
+	}
+	return &Probe{
+		target:    target,
+		client:    client,
+		healthURL: healthURL,
+		metrics:   m,
+		interval:  probe.IntervalFromRPS(target.RPS),
+	}, nil
+}
+
+func (p *Probe) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			go p.probeOnce(ctx)
+		}
+	}
+}
+
+func (p *Probe) probeOnce(ctx context.Context) {
+	stats := p.performRequest(ctx)
+
+	labels := prometheus.Labels{
+		"url":         p.target.URL,
+		"name":        p.target.Name,
+		"status_code": strconv.Itoa(stats.statusCode),
+		"result":      stats.result,
+	}
+	p.metrics.Requests.With(labels).Inc()
+	p.metrics.Durations.With(labels).Observe(stats.responseTime)
+
+	target := prometheus.Labels{
+		"url":  p.target.URL,
+		"name": p.target.Name,
+	}
+	// A cluster that stopped answering has no known status, rather than the
+	// last one it reported.
+	if stats.health == nil {
+		for _, status := range statuses {
+			p.metrics.ClusterStatus.With(prometheus.Labels{
+				"url":    p.target.URL,
+				"name":   p.target.Name,
+				"status": status,
+			}).Set(0)
+		}
+		p.metrics.UnassignedShards.Delete(target)
+		p.metrics.ActiveShardsPercent.Delete(target)
+		p.metrics.Nodes.Delete(target)
+		return
+	}
+
+	for _, status := range statuses {
+		value := 0.0
+		if status == stats.health.Status {
+			value = 1
+		}
+		p.metrics.ClusterStatus.With(prometheus.Labels{
+			"url":    p.target.URL,
+			"name":   p.target.Name,
+			"status": status,
+		}).Set(value)
+	}
+	p.metrics.UnassignedShards.With(target).Set(float64(stats.health.UnassignedShards))
+	p.metrics.ActiveShardsPercent.With(target).Set(stats.health.ActiveShardsPercent)
+	p.metrics.Nodes.With(target).Set(float64(stats.health.NumberOfNodes))
+}
+
+type clusterHealth struct {
+	Status              string  `json:"status"`
+	NumberOfNodes       int     `json:"number_of_nodes"`
+	UnassignedShards    int     `json:"unassigned_shards"`
+	ActiveShardsPercent float64 `json:"active_shards_percent_as_number"`
+}
+
+type esProbeStats struct {
+	statusCode   int
+	responseTime float64
+	result       string
+	health       *clusterHealth
+}
+
+func (p *Probe) performRequest(ctx context.Context) esProbeStats {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.healthURL, nil)
+	if err != nil {
+		return esProbeStats{result: "request_build_error"}
+	}
+	req.Header.Set("Accept", "application/json")
+	switch {
+	case p.target.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+p.target.APIKey)
+	case p.target.Username != "":
+		req.SetBasicAuth(p.target.Username, p.target.Password)
+	}
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return esProbeStats{
+			responseTime: time.Since(start).Seconds(),
+			result:       classifyError(err),
+		}
+	}
+	defer func() {
+		_, copyErr := io.Copy(io.Discard, resp.Body)
+		if err := resp.Body.Close(); err != nil {
+			klog.V(4).Infof("close elasticsearch response body failed: %v", err)
+		}
+		if copyErr != nil {
+			klog.V(4).Infof("drain elasticsearch response body failed: %v", copyErr)
+		}
+	}()
+
+	stats := esProbeStats{statusCode: resp.StatusCode}
+	if resp.StatusCode != http.StatusOK {
+		stats.responseTime = time.Since(start).Seconds()
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+			stats.result = "auth_failed"
+		default:
+			stats.result = "http_error"
+		}
+		return stats
+	}
+
+	var health clusterHealth
+	err = json.NewDecoder(resp.Body).Decode(&health)
+	stats.responseTime = time.Since(start).Seconds()
+	if err != nil {
+		klog.V(4).Infof("elasticsearch probe %q: decode cluster health: %v", p.target.Name, err)
+		stats.result = "parse_error"
+		return stats
+	}
+
+	stats.health = &health
+	stats.result = "es_success"
+	return stats
+}
+
+func classifyError(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		var dnsErr *net.DNSError
+		if errors.As(urlErr.Err, &dnsErr) {
+			return "dns_error"
+		}
+		var certErr *tls.CertificateVerificationError
+		if errors.As(urlErr.Err, &certErr) {
+			return "tls_error"
+		}
+	}
+
+	return "connection_failed"
+}
+
+func buildClient(target config.ElasticsearchTarget) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig := &tls.Config{InsecureSkipVerify: target.TLSSkipVerify}
+
+	if target.CAFile != "" {
+		pem, err := os.ReadFile(target.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca file %s: no certificates found", target.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{
+		Timeout:   target.Timeout,
+		Transport: transport,
+	}, nil
+}
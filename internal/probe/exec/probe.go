@@ -0,0 +1,220 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	klog "k8s.io/klog/v2"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/exposition"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/metrics"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/probe"
+)
+
+type Probe struct {
+	target   config.ExecTarget
+	metrics  *metrics.Exec
+	interval time.Duration
+	sem      chan struct{}
+
+	mu       sync.Mutex
+	lastKeys map[string]struct{}
+}
+
+func New(target config.ExecTarget, m *metrics.Exec) *Probe {
+	return &Probe{
+		target:   target,
+		metrics:  m,
+		interval: probe.IntervalFromRPS(target.RPS),
+		sem:      make(chan struct{}, target.MaxConcurrency),
+	}
+}
+
+func (p *Probe) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			select {
+			case p.sem <- struct{}{}:
+				go func() {
+					defer func() { <-p.sem }()
+					p.probeOnce(ctx)
+				}()
+			default:
+				klog.V(4).Infof("exec probe %q: %d runs still in flight, skipping", p.target.Name, p.target.MaxConcurrency)
+				p.metrics.Requests.With(prometheus.Labels{
+					"name":      p.target.Name,
+					"exit_code": "-1",
+					"result":    "skipped",
+				}).Inc()
+			}
+		}
+	}
+}
+
+func (p *Probe) probeOnce(ctx context.Context) {
+	stats := p.runCommand(ctx)
+	labels := prometheus.Labels{
+		"name":      p.target.Name,
+		"exit_code": strconv.Itoa(stats.exitCode),
+		"result":    stats.result,
+	}
+
+	p.metrics.Requests.With(labels).Inc()
+	p.metrics.Durations.With(labels).Observe(stats.responseTime)
+
+	if stats.values != nil {
+		p.setOutputValues(stats.values)
+	}
+}
+
+func (p *Probe) setOutputValues(values map[string]float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key := range p.lastKeys {
+		if _, ok := values[key]; !ok {
+			p.metrics.OutputValue.DeleteLabelValues(p.target.Name, key)
+		}
+	}
+
+	keys := make(map[string]struct{}, len(values))
+	for key, value := range values {
+		p.metrics.OutputValue.WithLabelValues(p.target.Name, key).Set(value)
+		keys[key] = struct{}{}
+	}
+	p.lastKeys = keys
+}
+
+type execProbeStats struct {
+	responseTime float64
+	exitCode     int
+	result       string
+	values       map[string]float64
+}
+
+func (p *Probe) runCommand(ctx context.Context) execProbeStats {
+	cmdCtx, cancel := context.WithTimeout(ctx, p.target.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(cmdCtx, p.target.Command, p.target.Args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Children that inherit stdout can keep the pipes open after the command
+	// is killed; don't wait on them forever.
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	stats := execProbeStats{
+		responseTime: time.Since(start).Seconds(),
+		exitCode:     -1,
+	}
+	if cmd.ProcessState != nil {
+		stats.exitCode = cmd.ProcessState.ExitCode()
+	}
+
+	if err != nil {
+		klog.V(4).Infof("exec probe %q failed: %v: %s", p.target.Name, err, strings.TrimSpace(stderr.String()))
+		var exitErr *exec.ExitError
+		switch {
+		case errors.Is(cmdCtx.Err(), context.DeadlineExceeded):
+			stats.result = "timeout"
+		case errors.As(err, &exitErr):
+			stats.result = "exec_failed"
+		default:
+			stats.result = "start_error"
+		}
+		return stats
+	}
+
+	if p.target.OutputFormat != "" {
+		values, err := parseOutput(p.target.OutputFormat, &stdout)
+		if err != nil {
+			klog.V(4).Infof("exec probe %q: parse output: %v", p.target.Name, err)
+			stats.result = "parse_error"
+			return stats
+		}
+		stats.values = values
+	}
+
+	stats.result = "exec_success"
+	return stats
+}
+
+func parseOutput(format string, r io.Reader) (map[string]float64, error) {
+	switch format {
+	case "prometheus":
+		return parsePrometheus(r)
+	case "json":
+		return parseJSON(r)
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+func parsePrometheus(r io.Reader) (map[string]float64, error) {
+	samples, err := exposition.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]float64, len(samples))
+	for _, sample := range samples {
+		values[sample.Key()] = sample.Value
+	}
+	return values, nil
+}
+
+func parseJSON(r io.Reader) (map[string]float64, error) {
+	var doc map[string]any
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]float64)
+	flattenJSON("", doc, values)
+	return values, nil
+}
+
+func flattenJSON(prefix string, v any, out map[string]float64) {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, child := range val {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flattenJSON(key, child, out)
+		}
+	case float64:
+		out[prefix] = val
+	case bool:
+		if val {
+			out[prefix] = 1
+		} else {
+			out[prefix] = 0
+		}
+	case string:
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			out[prefix] = f
+		}
+	}
+}
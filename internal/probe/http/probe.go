@@ -192,6 +192,11 @@ func buildClient(target config.HTTPTarget) *http.Client {
 
 	transport := http.DefaultTransport.(*http.Transport).Clone()
 	transport.DisableKeepAlives = target.DisableKeepAlives
+	// Same timeouts as the dialer of http.DefaultTransport.
+	dialer := probe.Dialer(target.SocketOptions)
+	dialer.Timeout = 30 * time.Second
+	dialer.KeepAlive = 30 * time.Second
+	transport.DialContext = dialer.DialContext
 	if target.TLSSkipVerify {
 		if transport.TLSClientConfig == nil {
 			transport.TLSClientConfig = &tls.Config{}
@@ -206,7 +211,7 @@ func buildHTTP2Transport(target config.HTTPTarget) http.RoundTripper {
 	t := &http2.Transport{
 		AllowHTTP: true,
 		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
-			return net.Dial(network, addr)
+			return probe.Dialer(target.SocketOptions).Dial(network, addr)
 		},
 	}
 	if target.TLSSkipVerify {
@@ -0,0 +1,80 @@
+//go:build linux
+
+package icmp
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/probe"
+)
+
+// dialTTL connects to address from port, or any port if 0, with the TTL of the
+// SYN set to ttl. The socket is bound before connecting so bound learns the
+// local port the ICMP errors about the SYN will quote.
+func dialTTL(ctx context.Context, network, address string, ttl, port int, opts config.SocketOptions, bound func(port int) error) (net.Conn, error) {
+	control := probe.Control(opts)
+	d := net.Dialer{
+		Control: func(network, address string, c syscall.RawConn) error {
+			if control != nil {
+				if err := control(network, address, c); err != nil {
+					return err
+				}
+			}
+			var serr error
+			if err := c.Control(func(fd uintptr) {
+				serr = bindTTL(int(fd), network, ttl, opts.SourceIP, port, bound)
+			}); err != nil {
+				return err
+			}
+			return serr
+		},
+	}
+	return d.DialContext(ctx, network, address)
+}
+
+func bindTTL(fd int, network string, ttl int, source string, port int, bound func(port int) error) error {
+	sa4, sa6 := &unix.SockaddrInet4{Port: port}, &unix.SockaddrInet6{Port: port}
+	if src, err := netip.ParseAddr(source); err == nil {
+		if src.Unmap().Is4() {
+			sa4.Addr = src.Unmap().As4()
+		} else {
+			sa6.Addr = src.As16()
+		}
+	}
+	var sa unix.Sockaddr = sa4
+	level, opt := unix.IPPROTO_IP, unix.IP_TTL
+	if strings.HasSuffix(network, "6") {
+		sa = sa6
+		level, opt = unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS
+	}
+	if err := unix.SetsockoptInt(fd, level, opt, ttl); err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+	if port != 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		return os.NewSyscallError("bind", err)
+	}
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return os.NewSyscallError("getsockname", err)
+	}
+	switch a := sa.(type) {
+	case *unix.SockaddrInet4:
+		return bound(a.Port)
+	case *unix.SockaddrInet6:
+		return bound(a.Port)
+	}
+	return nil
+}
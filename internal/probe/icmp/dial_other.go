@@ -0,0 +1,15 @@
+//go:build !linux
+
+package icmp
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+)
+
+func dialTTL(ctx context.Context, network, address string, ttl, port int, opts config.SocketOptions, bound func(port int) error) (net.Conn, error) {
+	return nil, errors.New("tcp traceroute is only supported on linux")
+}
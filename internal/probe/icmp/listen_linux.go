@@ -0,0 +1,91 @@
+//go:build linux
+
+package icmp
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/sys/unix"
+)
+
+// listen opens the ICMP socket of key bound to address. icmp.ListenPacket has
+// no way to set options before binding, so sockets that set the DF bit or are
+// bound to an interface are opened here.
+//
+// IP_PMTUDISC_PROBE is used rather than IP_PMTUDISC_DO so every echo tests the
+// path instead of the kernel's cached path MTU.
+func listen(key socketKey, address string, ipv6 bool) (net.PacketConn, error) {
+	if !key.dontFragment && key.iface == "" {
+		return icmp.ListenPacket(key.network, address)
+	}
+
+	family, proto := unix.AF_INET, protocolICMP
+	if ipv6 {
+		family, proto = unix.AF_INET6, protocolICMPv6
+	}
+	setOptions := func(fd int) error {
+		if key.dontFragment {
+			if err := setDontFragment(fd, family); err != nil {
+				return err
+			}
+		}
+		if key.iface != "" {
+			return os.NewSyscallError("setsockopt", unix.BindToDevice(fd, key.iface))
+		}
+		return nil
+	}
+
+	if !strings.HasPrefix(key.network, "udp") {
+		lc := net.ListenConfig{
+			Control: func(_, _ string, c syscall.RawConn) error {
+				var serr error
+				if err := c.Control(func(fd uintptr) {
+					serr = setOptions(int(fd))
+				}); err != nil {
+					return err
+				}
+				return serr
+			},
+		}
+		return lc.ListenPacket(context.Background(), key.network, address)
+	}
+
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return nil, err
+	}
+	var sa unix.Sockaddr = &unix.SockaddrInet4{Addr: addr.As4()}
+	if ipv6 {
+		sa = &unix.SockaddrInet6{Addr: addr.As16()}
+	}
+
+	fd, err := unix.Socket(family, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := setOptions(fd); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	f := os.NewFile(uintptr(fd), "datagram-oriented icmp")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
+
+func setDontFragment(fd, family int) error {
+	if family == unix.AF_INET6 {
+		return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_PROBE))
+	}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE))
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package icmp
+
+import (
+	"errors"
+	"net"
+
+	"golang.org/x/net/icmp"
+)
+
+func listen(key socketKey, address string, ipv6 bool) (net.PacketConn, error) {
+	if key.dontFragment || key.iface != "" {
+		return nil, errors.New("don't-fragment and interface icmp sockets are only supported on linux")
+	}
+	return icmp.ListenPacket(key.network, address)
+}
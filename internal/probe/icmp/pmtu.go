@@ -0,0 +1,70 @@
+package icmp
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+const (
+	// Header bytes in front of the echo payload: IP plus the 8 byte ICMP
+	// header.
+	ipv4EchoOverhead = 20 + 8
+	ipv6EchoOverhead = 40 + 8
+)
+
+// discoverPathMTU binary-searches the largest echo that reaches dst with the
+// don't-fragment bit set and returns it as a packet size including headers.
+// An echo is too big if it is refused locally with EMSGSIZE or gets no reply.
+func discoverPathMTU(ctx context.Context, sock *socket, dst netip.Addr, ttl, maxMTU int, timeout time.Duration) (int, error) {
+	overhead := ipv4EchoOverhead
+	if dst.Is6() {
+		overhead = ipv6EchoOverhead
+	}
+
+	fits := func(size int) (bool, error) {
+		r, err := sock.ping(ctx, dst, ttl, size, timeout)
+		if errors.Is(err, syscall.EMSGSIZE) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return r.event == echoReplied, nil
+	}
+
+	if ok, err := fits(0); err != nil || !ok {
+		if err == nil {
+			err = errors.New("host does not answer echoes")
+		}
+		return 0, err
+	}
+
+	lo, hi := 0, maxMTU-overhead
+	if hi < lo {
+		hi = lo
+	}
+	ok, err := fits(hi)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		return hi + overhead, nil
+	}
+	// lo is known to fit and hi known not to.
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		ok, err := fits(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo + overhead, nil
+}
@@ -2,10 +2,15 @@ package icmp
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/go-ping/ping"
 	"github.com/prometheus/client_golang/prometheus"
 	klog "k8s.io/klog/v2"
 
@@ -18,14 +23,36 @@ type Probe struct {
 	target   config.ICMPTarget
 	metrics  *metrics.ICMP
 	interval time.Duration
+	// networks are passed to net.ResolveIPAddr, one burst each.
+	networks []string
+
+	pmtuMu sync.Mutex
+	// pmtuNext is when path MTU discovery is next due for each ip version.
+	// A search that is still running keeps the next one from starting.
+	pmtuNext    map[string]time.Time
+	pmtuRunning map[string]bool
 }
 
 func New(target config.ICMPTarget, m *metrics.ICMP) *Probe {
-	return &Probe{
+	p := &Probe{
 		target:   target,
 		metrics:  m,
 		interval: probe.IntervalFromRPS(target.RPS),
+
+		pmtuNext:    map[string]time.Time{},
+		pmtuRunning: map[string]bool{},
+	}
+	switch target.IPVersion {
+	case config.ICMPIPVersion4:
+		p.networks = []string{"ip4"}
+	case config.ICMPIPVersion6:
+		p.networks = []string{"ip6"}
+	case config.ICMPIPVersionDual:
+		p.networks = []string{"ip4", "ip6"}
+	default:
+		p.networks = []string{"ip"}
 	}
+	return p
 }
 
 func (p *Probe) Run(ctx context.Context) error {
@@ -37,60 +64,345 @@ func (p *Probe) Run(ctx context.Context) error {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-ticker.C:
-			go p.probeOnce()
+			go p.probeOnce(ctx)
 		}
 	}
 }
 
-func (p *Probe) probeOnce() {
-	stats := p.sendRequest()
-	result := "icmp_error"
-	if stats.err == nil {
-		result = "icmp_success"
-	} else {
+func (p *Probe) probeOnce(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, network := range p.networks {
+		wg.Add(1)
+		go func(network string) {
+			defer wg.Done()
+			p.probeNetwork(ctx, network)
+		}(network)
+	}
+	wg.Wait()
+}
+
+func (p *Probe) probeNetwork(ctx context.Context, network string) {
+	stats := p.sendRequest(ctx, network)
+	if p.target.PMTUDiscovery && stats.dst.IsValid() && p.startPathMTU(stats.ipVersion) {
+		defer p.observePathMTU(ctx, stats)
+	}
+	if stats.err != nil {
 		klog.V(4).Infof("icmp probe failure for host %s: %v", p.target.Host, stats.err)
+		p.metrics.Requests.With(p.labels(stats.ipVersion, p.target.TTL, "icmp_error")).Inc()
+	}
+
+	for _, reply := range stats.replies {
+		labels := p.labels(stats.ipVersion, reply.ttl, "icmp_success")
+		p.metrics.Requests.With(labels).Inc()
+		p.metrics.Durations.With(labels).Observe(reply.rtt.Seconds())
+	}
+	if stats.unreachable > 0 {
+		p.metrics.Requests.With(p.labels(stats.ipVersion, p.target.TTL, "icmp_unreachable")).Add(float64(stats.unreachable))
+	}
+	if stats.ttlExceeded > 0 {
+		p.metrics.Requests.With(p.labels(stats.ipVersion, p.target.TTL, "icmp_ttl_exceeded")).Add(float64(stats.ttlExceeded))
+	}
+	if lost := stats.sent - len(stats.replies) - stats.unreachable - stats.ttlExceeded; lost > 0 {
+		p.metrics.Requests.With(p.labels(stats.ipVersion, p.target.TTL, "icmp_timeout")).Add(float64(lost))
+	}
+
+	if len(stats.flows) > 0 {
+		p.observeFlows(stats)
+	}
+
+	if stats.sent == 0 {
+		return
+	}
+	hostLabels := prometheus.Labels{
+		"name":       p.target.Name,
+		"host":       p.target.Host,
+		"ip_version": stats.ipVersion,
 	}
+	p.metrics.PacketLoss.With(hostLabels).Set(float64(stats.sent-len(stats.replies)) / float64(stats.sent))
+	p.metrics.Duplicates.With(hostLabels).Add(float64(stats.duplicates))
+	p.metrics.OutOfOrder.With(hostLabels).Add(float64(stats.outOfOrder))
+	if len(stats.replies) == 0 {
+		return
+	}
+	minRTT, avgRTT, maxRTT, stdDevRTT := rttStatistics(stats.replies)
+	for stat, rtt := range map[string]time.Duration{
+		"min": minRTT,
+		"avg": avgRTT,
+		"max": maxRTT,
+	} {
+		p.metrics.RTT.With(prometheus.Labels{
+			"name":       p.target.Name,
+			"host":       p.target.Host,
+			"ip_version": stats.ipVersion,
+			"stat":       stat,
+		}).Set(rtt.Seconds())
+	}
+	p.metrics.Jitter.With(hostLabels).Set(stdDevRTT.Seconds())
+}
+
+// observeFlows exports the loss and latency of every flow, so a failing ECMP
+// path shows up as one bad flow instead of diluting the totals.
+func (p *Probe) observeFlows(stats icmpStats) {
+	for flow, fs := range stats.flows {
+		if fs.sent == 0 {
+			continue
+		}
+		labels := prometheus.Labels{
+			"name":       p.target.Name,
+			"host":       p.target.Host,
+			"ip_version": stats.ipVersion,
+			"flow":       strconv.Itoa(flow),
+		}
+		p.metrics.FlowPacketLoss.With(labels).Set(float64(fs.sent-len(fs.replies)) / float64(fs.sent))
+		if len(fs.replies) == 0 {
+			p.metrics.FlowRTT.Delete(labels)
+			continue
+		}
+		_, avgRTT, _, _ := rttStatistics(fs.replies)
+		p.metrics.FlowRTT.With(labels).Set(avgRTT.Seconds())
+	}
+}
+
+// startPathMTU reports whether path MTU discovery is due for ipVersion and,
+// if so, claims it until observePathMTU is done.
+func (p *Probe) startPathMTU(ipVersion string) bool {
+	p.pmtuMu.Lock()
+	defer p.pmtuMu.Unlock()
+	now := time.Now()
+	if p.pmtuRunning[ipVersion] || now.Before(p.pmtuNext[ipVersion]) {
+		return false
+	}
+	p.pmtuRunning[ipVersion] = true
+	p.pmtuNext[ipVersion] = now.Add(p.target.PMTUInterval)
+	return true
+}
+
+// observePathMTU runs even when the burst got no replies, as oversized echoes
+// with the don't-fragment bit set are exactly what a black hole drops. The
+// gauge keeps the last result until the next search.
+func (p *Probe) observePathMTU(ctx context.Context, stats icmpStats) {
+	defer func() {
+		p.pmtuMu.Lock()
+		p.pmtuRunning[stats.ipVersion] = false
+		p.pmtuMu.Unlock()
+	}()
 
 	labels := prometheus.Labels{
-		"host":   p.target.Host,
-		"name":   p.target.Name,
-		"ttl":    strconv.Itoa(stats.ttl),
-		"result": result,
+		"name":       p.target.Name,
+		"host":       p.target.Host,
+		"ip_version": stats.ipVersion,
 	}
+	sock, err := socketTo(stats.dst, p.target.Privileged, true, 0, p.target.SocketOptions)
+	if err == nil {
+		var mtu int
+		mtu, err = discoverPathMTU(ctx, sock, stats.dst, p.target.TTL, p.target.MaxMTU, p.target.Timeout)
+		if err == nil {
+			p.metrics.PathMTU.With(labels).Set(float64(mtu))
+			return
+		}
+	}
+	klog.V(4).Infof("icmp path mtu discovery failed for host %s: %v", p.target.Host, err)
+}
 
-	p.metrics.Requests.With(labels).Inc()
-	p.metrics.Durations.With(labels).Observe(stats.rtt.Seconds())
+func (p *Probe) labels(ipVersion string, ttl int, result string) prometheus.Labels {
+	return prometheus.Labels{
+		"host":       p.target.Host,
+		"name":       p.target.Name,
+		"ttl":        strconv.Itoa(ttl),
+		"result":     result,
+		"ip_version": ipVersion,
+	}
 }
 
-type icmpStats struct {
+type icmpReply struct {
 	rtt time.Duration
 	ttl int
-	err error
 }
 
-func (p *Probe) sendRequest() icmpStats {
-	pinger, err := ping.NewPinger(p.target.Host)
+type icmpStats struct {
+	ipVersion  string
+	dst        netip.Addr
+	replies    []icmpReply
+	sent       int
+	duplicates int
+	outOfOrder int
+	// unreachable and ttlExceeded count echoes answered with an ICMP error
+	// rather than lost, which only raw sockets can tell.
+	unreachable int
+	ttlExceeded int
+	err         error
+	// flows holds the stats of each flow the echoes were spread over.
+	flows []icmpStats
+}
+
+// sendRequest resolves the host within network and sends a burst over the
+// shared socket of that address family, or one over each flow's socket.
+func (p *Probe) sendRequest(ctx context.Context, network string) icmpStats {
+	ipVersion := strings.TrimPrefix(network, "ip")
+	addr, err := net.ResolveIPAddr(network, p.target.Host)
 	if err != nil {
-		return icmpStats{err: err}
+		return icmpStats{ipVersion: ipVersion, err: err}
 	}
+	dst, ok := netip.AddrFromSlice(addr.IP)
+	if !ok {
+		return icmpStats{ipVersion: ipVersion, err: fmt.Errorf("invalid address %s", addr)}
+	}
+	dst = dst.Unmap().WithZone(addr.Zone)
 
-	pinger.Count = 1
-	pinger.Timeout = p.target.Timeout
-	pinger.TTL = p.target.TTL
-	pinger.SetPrivileged(false)
+	ipVersion = config.ICMPIPVersion4
+	if dst.Is6() {
+		ipVersion = config.ICMPIPVersion6
+	}
+	if p.target.Flows <= 1 {
+		stats := p.burst(ctx, dst, 0)
+		stats.ipVersion = ipVersion
+		return stats
+	}
 
-	result := icmpStats{ttl: p.target.TTL}
-	pinger.OnRecv = func(pkt *ping.Packet) {
-		result = icmpStats{
-			rtt: pkt.Rtt,
-			ttl: pkt.Ttl,
+	result := icmpStats{
+		ipVersion: ipVersion,
+		dst:       dst,
+		flows:     make([]icmpStats, p.target.Flows),
+	}
+	var wg sync.WaitGroup
+	for flow := range result.flows {
+		wg.Add(1)
+		go func(flow int) {
+			defer wg.Done()
+			result.flows[flow] = p.burst(ctx, dst, flow)
+		}(flow)
+	}
+	wg.Wait()
+
+	for _, stats := range result.flows {
+		result.replies = append(result.replies, stats.replies...)
+		result.sent += stats.sent
+		result.duplicates += stats.duplicates
+		result.outOfOrder += stats.outOfOrder
+		result.unreachable += stats.unreachable
+		result.ttlExceeded += stats.ttlExceeded
+		if result.err == nil {
+			result.err = stats.err
 		}
 	}
+	return result
+}
+
+// burst sends Count echoes Interval apart over the socket of flow, waiting for
+// each to be answered or to time out.
+func (p *Probe) burst(ctx context.Context, dst netip.Addr, flow int) icmpStats {
+	sock, err := socketTo(dst, p.target.Privileged, p.target.DontFragment, flow, p.target.SocketOptions)
+	if err != nil {
+		return icmpStats{err: err}
+	}
 
-	if err := pinger.Run(); err != nil {
-		result.err = err
-		return result
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		result  = icmpStats{dst: dst}
+		lastIdx = -1
+	)
+burst:
+	for i := 0; i < p.target.Count; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				break burst
+			case <-time.After(p.target.Interval):
+			}
+		}
+
+		i := i
+		wg.Add(1)
+		err := sock.send(dst, p.target.TTL, p.target.Size, p.target.Timeout, func(r echoReply) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch r.event {
+			case echoDuplicate:
+				result.duplicates++
+				return
+			case echoReplied:
+				result.replies = append(result.replies, icmpReply{rtt: r.rtt, ttl: r.ttl})
+				if i < lastIdx {
+					result.outOfOrder++
+				} else {
+					lastIdx = i
+				}
+			case echoUnreachable:
+				result.unreachable++
+			case echoTimeExceeded:
+				result.ttlExceeded++
+			}
+			wg.Done()
+		})
+		if err != nil {
+			wg.Done()
+			mu.Lock()
+			result.err = err
+			mu.Unlock()
+			break
+		}
+		mu.Lock()
+		result.sent++
+		mu.Unlock()
 	}
+	wg.Wait()
 
+	mu.Lock()
+	defer mu.Unlock()
 	return result
 }
+
+// socketTo returns the shared socket for echoes of flow to dst sent as opts
+// ask.
+func socketTo(dst netip.Addr, privileged, dontFragment bool, flow int, opts config.SocketOptions) (*socket, error) {
+	if opts.SourceIP != "" {
+		if src, err := netip.ParseAddr(opts.SourceIP); err == nil && src.Unmap().Is6() != dst.Is6() {
+			return nil, fmt.Errorf("source_ip %s can't reach %s", opts.SourceIP, dst)
+		}
+	}
+	return socketFor(socketKey{
+		network:      socketNetwork(dst.Is6(), privileged),
+		dontFragment: dontFragment,
+		tos:          opts.TrafficClass(),
+		source:       opts.SourceIP,
+		iface:        opts.Interface,
+		flow:         flow,
+	})
+}
+
+// socketNetwork returns the icmp.ListenPacket network of an address family.
+func socketNetwork(ipv6, privileged bool) string {
+	switch {
+	case ipv6 && privileged:
+		return "ip6:ipv6-icmp"
+	case ipv6:
+		return "udp6"
+	case privileged:
+		return "ip4:icmp"
+	default:
+		return "udp4"
+	}
+}
+
+func rttStatistics(replies []icmpReply) (minRTT, avgRTT, maxRTT, stdDevRTT time.Duration) {
+	var sum time.Duration
+	for i, r := range replies {
+		if i == 0 || r.rtt < minRTT {
+			minRTT = r.rtt
+		}
+		if r.rtt > maxRTT {
+			maxRTT = r.rtt
+		}
+		sum += r.rtt
+	}
+	avgRTT = sum / time.Duration(len(replies))
+
+	var variance float64
+	for _, r := range replies {
+		d := float64(r.rtt - avgRTT)
+		variance += d * d
+	}
+	stdDevRTT = time.Duration(math.Sqrt(variance / float64(len(replies))))
+	return minRTT, avgRTT, maxRTT, stdDevRTT
+}
@@ -0,0 +1,486 @@
+package icmp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	protocolICMP   = 1
+	protocolTCP    = 6
+	protocolUDP    = 17
+	protocolICMPv6 = 58
+)
+
+var errNoFreeSequence = errors.New("no free icmp sequence number")
+
+type echoEvent int
+
+const (
+	echoReplied echoEvent = iota
+	echoTimedOut
+	echoDuplicate
+	// The probe was answered with an ICMP error by from, which is only seen
+	// on raw sockets.
+	echoTimeExceeded
+	echoUnreachable
+)
+
+type echoReply struct {
+	event echoEvent
+	rtt   time.Duration
+	ttl   int
+	from  netip.Addr
+}
+
+// probeKey identifies what an ICMP message answers: an echo by its sequence
+// number, or a UDP or TCP probe sent by another socket by its source port.
+type probeKey struct {
+	proto int
+	id    uint16
+}
+
+// echo is an outstanding probe. notify is called exactly once with
+// echoTimedOut or the first answer, and with echoDuplicate for every further
+// answer received before the deadline.
+type echo struct {
+	sock     *socket
+	key      probeKey
+	dst      netip.Addr
+	sent     time.Time
+	deadline time.Time
+	rounds   int
+	replied  bool
+	notify   func(echoReply)
+}
+
+// socketKey identifies a shared socket. Targets that set the don't-fragment
+// bit, a TOS or where packets leave from get sockets of their own, as those
+// are socket options. Every flow gets a socket, and so an identifier, of its
+// own too.
+type socketKey struct {
+	network      string
+	dontFragment bool
+	tos          int
+	source       string
+	iface        string
+	flow         int
+}
+
+// socket is shared by every target of one network. Echoes are told apart by
+// their sequence number; datagram sockets get their ID rewritten by the
+// kernel and only ever see their own replies, while raw sockets see every
+// echo reply on the host and are filtered by ID.
+type socket struct {
+	network string
+	proto   int
+	conn    net.PacketConn
+	p4      *ipv4.PacketConn
+	p6      *ipv6.PacketConn
+	id      int
+	checkID bool
+	wheel   *timerWheel
+
+	sendMu sync.Mutex
+	ttl    int
+
+	mu      sync.Mutex
+	seq     uint16
+	pending map[probeKey]*echo
+}
+
+var (
+	socketsMu sync.Mutex
+	sockets   = make(map[socketKey]*socket)
+	// socketIDs counts the identifiers handed out. Raw sockets see each
+	// other's replies and each keeps its own sequence numbers, so no two may
+	// share an identifier.
+	socketIDs int
+)
+
+// socketFor returns the shared socket of key, opening it on first use. Every
+// socket gets an identifier of its own.
+func socketFor(key socketKey) (*socket, error) {
+	socketsMu.Lock()
+	defer socketsMu.Unlock()
+
+	if s, ok := sockets[key]; ok {
+		return s, nil
+	}
+	s, err := openSocket(key, (os.Getpid()+socketIDs)&0xffff)
+	if err != nil {
+		return nil, err
+	}
+	socketIDs++
+	sockets[key] = s
+	go s.readLoop()
+	return s, nil
+}
+
+func openSocket(key socketKey, id int) (*socket, error) {
+	network := key.network
+	s := &socket{
+		network: network,
+		id:      id,
+		checkID: !strings.HasPrefix(network, "udp"),
+		wheel:   sharedWheel(),
+		pending: make(map[probeKey]*echo),
+	}
+
+	address := "0.0.0.0"
+	s.proto = protocolICMP
+	if strings.HasSuffix(network, "6") || strings.HasPrefix(network, "ip6") {
+		address = "::"
+		s.proto = protocolICMPv6
+	}
+	if key.source != "" {
+		address = key.source
+	}
+
+	var err error
+	s.conn, err = listen(key, address, s.proto == protocolICMPv6)
+	if err != nil {
+		return nil, fmt.Errorf("open %s icmp socket: %w", network, err)
+	}
+
+	if s.proto == protocolICMP {
+		if c, ok := s.conn.(*icmp.PacketConn); ok {
+			s.p4 = c.IPv4PacketConn()
+		} else {
+			s.p4 = ipv4.NewPacketConn(s.conn)
+		}
+		err = s.p4.SetControlMessage(ipv4.FlagTTL, true)
+	} else {
+		if c, ok := s.conn.(*icmp.PacketConn); ok {
+			s.p6 = c.IPv6PacketConn()
+		} else {
+			s.p6 = ipv6.NewPacketConn(s.conn)
+		}
+		err = s.p6.SetControlMessage(ipv6.FlagHopLimit, true)
+	}
+	if err != nil {
+		klog.Warningf("icmp %s socket: reply ttl unavailable: %v", network, err)
+	}
+
+	if key.tos != 0 {
+		if s.p4 != nil {
+			err = s.p4.SetTOS(key.tos)
+		} else {
+			err = s.p6.SetTrafficClass(key.tos)
+		}
+		if err != nil {
+			s.conn.Close()
+			return nil, fmt.Errorf("set %s icmp socket tos: %w", network, err)
+		}
+	}
+	return s, nil
+}
+
+// send transmits an echo request carrying size bytes of payload.
+func (s *socket) send(dst netip.Addr, ttl, size int, timeout time.Duration, notify func(echoReply)) error {
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{
+			ID:   s.id,
+			Data: make([]byte, size),
+		},
+	}
+	if s.proto == protocolICMPv6 {
+		msg.Type = ipv6.ICMPTypeEchoRequest
+	}
+
+	s.mu.Lock()
+	seq, ok := s.nextSeq()
+	if !ok {
+		s.mu.Unlock()
+		return errNoFreeSequence
+	}
+	e := &echo{sock: s, key: probeKey{proto: s.proto, id: seq}, dst: dst, notify: notify}
+	s.pending[e.key] = e
+	s.mu.Unlock()
+
+	msg.Body.(*icmp.Echo).Seq = int(seq)
+	b, err := msg.Marshal(nil)
+	if err == nil {
+		err = s.write(e, b, ttl, timeout)
+	}
+	if err != nil {
+		s.mu.Lock()
+		delete(s.pending, e.key)
+		s.mu.Unlock()
+		return err
+	}
+
+	s.wheel.add(e)
+	return nil
+}
+
+// expect waits for ICMP errors about a UDP or TCP probe that the caller is
+// about to send from the local port in key. Sockets other than raw ones never
+// see those errors. A port is free again once its last probe was answered.
+func (s *socket) expect(key probeKey, dst netip.Addr, timeout time.Duration, notify func(echoReply)) (*echo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, busy := s.pending[key]; busy && !e.replied {
+		return nil, fmt.Errorf("port %d is already being probed", key.id)
+	}
+	e := &echo{sock: s, key: key, dst: dst, notify: notify}
+	e.sent = time.Now()
+	e.deadline = e.sent.Add(timeout)
+	s.pending[key] = e
+	s.wheel.add(e)
+	return e, nil
+}
+
+// forget drops e without notifying, for probes answered some other way.
+func (s *socket) forget(e *echo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.replied = true
+	if s.pending[e.key] == e {
+		delete(s.pending, e.key)
+	}
+}
+
+// ping sends a single echo and waits for its reply or timeout.
+func (s *socket) ping(ctx context.Context, dst netip.Addr, ttl, size int, timeout time.Duration) (echoReply, error) {
+	w := newReplyWaiter()
+	if err := s.send(dst, ttl, size, timeout, w.notify); err != nil {
+		return echoReply{}, err
+	}
+	return w.wait(ctx)
+}
+
+// replyWaiter turns the notifications of a single probe into a blocking call.
+type replyWaiter chan echoReply
+
+func newReplyWaiter() replyWaiter {
+	return make(replyWaiter, 1)
+}
+
+func (w replyWaiter) notify(r echoReply) {
+	if r.event != echoDuplicate {
+		w <- r
+	}
+}
+
+func (w replyWaiter) wait(ctx context.Context) (echoReply, error) {
+	select {
+	case r := <-w:
+		return r, nil
+	case <-ctx.Done():
+		return echoReply{}, ctx.Err()
+	}
+}
+
+// write sends b with the given TTL. The TTL is a socket option, so writes are
+// serialized and the option is only touched when it changes.
+func (s *socket) write(e *echo, b []byte, ttl int, timeout time.Duration) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	if ttl != s.ttl {
+		var err error
+		if s.p4 != nil {
+			err = s.p4.SetTTL(ttl)
+		} else {
+			err = s.p6.SetHopLimit(ttl)
+		}
+		if err != nil {
+			return fmt.Errorf("set ttl: %w", err)
+		}
+		s.ttl = ttl
+	}
+
+	s.mu.Lock()
+	e.sent = time.Now()
+	e.deadline = e.sent.Add(timeout)
+	s.mu.Unlock()
+
+	_, err := s.conn.WriteTo(b, s.addr(e.dst))
+	return err
+}
+
+func (s *socket) addr(dst netip.Addr) net.Addr {
+	if strings.HasPrefix(s.network, "udp") {
+		return &net.UDPAddr{IP: dst.AsSlice(), Zone: dst.Zone()}
+	}
+	return &net.IPAddr{IP: dst.AsSlice(), Zone: dst.Zone()}
+}
+
+// nextSeq must be called with s.mu held.
+func (s *socket) nextSeq() (uint16, bool) {
+	for i := 0; i <= 0xffff; i++ {
+		s.seq++
+		if _, busy := s.pending[probeKey{proto: s.proto, id: s.seq}]; !busy {
+			return s.seq, true
+		}
+	}
+	return 0, false
+}
+
+func (s *socket) readLoop() {
+	buf := make([]byte, 65535)
+	for {
+		n, ttl, peer, err := s.read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			klog.V(4).Infof("icmp %s socket read failed: %v", s.network, err)
+			continue
+		}
+		received := time.Now()
+
+		msg, err := icmp.ParseMessage(s.proto, buf[:n])
+		if err != nil {
+			continue
+		}
+		switch body := msg.Body.(type) {
+		case *icmp.Echo:
+			if msg.Type != ipv4.ICMPTypeEchoReply && msg.Type != ipv6.ICMPTypeEchoReply {
+				continue
+			}
+			if s.checkID && body.ID != s.id {
+				continue
+			}
+			key := probeKey{proto: s.proto, id: uint16(body.Seq)}
+			s.handleReply(key, peer, peer, ttl, received, echoReplied)
+		case *icmp.TimeExceeded:
+			if key, dst, ok := s.parseQuoted(body.Data); ok {
+				s.handleReply(key, dst, peer, ttl, received, echoTimeExceeded)
+			}
+		case *icmp.DstUnreach:
+			if key, dst, ok := s.parseQuoted(body.Data); ok {
+				s.handleReply(key, dst, peer, ttl, received, echoUnreachable)
+			}
+		case *icmp.PacketTooBig:
+			if key, dst, ok := s.parseQuoted(body.Data); ok {
+				s.handleReply(key, dst, peer, ttl, received, echoUnreachable)
+			}
+		}
+	}
+}
+
+// parseQuoted returns the probe and destination of the packet quoted by an
+// ICMP error, which is its IP header followed by at least 8 bytes.
+func (s *socket) parseQuoted(b []byte) (probeKey, netip.Addr, bool) {
+	var (
+		proto int
+		dst   net.IP
+	)
+	if s.proto == protocolICMP {
+		h, err := ipv4.ParseHeader(b)
+		if err != nil || len(b) < h.Len {
+			return probeKey{}, netip.Addr{}, false
+		}
+		proto, dst, b = h.Protocol, h.Dst, b[h.Len:]
+	} else {
+		h, err := ipv6.ParseHeader(b)
+		if err != nil {
+			return probeKey{}, netip.Addr{}, false
+		}
+		proto, dst, b = h.NextHeader, h.Dst, b[ipv6.HeaderLen:]
+	}
+	if len(b) < 8 {
+		return probeKey{}, netip.Addr{}, false
+	}
+	addr, _ := netip.AddrFromSlice(dst)
+
+	switch proto {
+	case s.proto:
+		// type, code, checksum, identifier and sequence number
+		if b[0] != byte(ipv4.ICMPTypeEcho) && b[0] != byte(ipv6.ICMPTypeEchoRequest) {
+			return probeKey{}, netip.Addr{}, false
+		}
+		if s.checkID && int(binary.BigEndian.Uint16(b[4:6])) != s.id {
+			return probeKey{}, netip.Addr{}, false
+		}
+		return probeKey{proto: proto, id: binary.BigEndian.Uint16(b[6:8])}, addr.Unmap(), true
+	case protocolUDP, protocolTCP:
+		return probeKey{proto: proto, id: binary.BigEndian.Uint16(b[0:2])}, addr.Unmap(), true
+	}
+	return probeKey{}, netip.Addr{}, false
+}
+
+func (s *socket) read(buf []byte) (int, int, netip.Addr, error) {
+	var (
+		n    int
+		ttl  = -1
+		peer net.Addr
+		err  error
+	)
+	if s.p4 != nil {
+		var cm *ipv4.ControlMessage
+		n, cm, peer, err = s.p4.ReadFrom(buf)
+		if cm != nil {
+			ttl = cm.TTL
+		}
+	} else {
+		var cm *ipv6.ControlMessage
+		n, cm, peer, err = s.p6.ReadFrom(buf)
+		if cm != nil {
+			ttl = cm.HopLimit
+		}
+	}
+	if err != nil {
+		return 0, 0, netip.Addr{}, err
+	}
+
+	var ip net.IP
+	switch a := peer.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	return n, ttl, addr.Unmap(), nil
+}
+
+// handleReply notifies the probe of key sent to dst that peer answered it.
+func (s *socket) handleReply(key probeKey, dst, peer netip.Addr, ttl int, received time.Time, event echoEvent) {
+	s.mu.Lock()
+	e, ok := s.pending[key]
+	if !ok || e.sent.IsZero() || e.dst.WithZone("") != dst.WithZone("") {
+		s.mu.Unlock()
+		return
+	}
+	reply := echoReply{event: event, rtt: received.Sub(e.sent), ttl: ttl, from: peer}
+	if e.replied {
+		reply.event = echoDuplicate
+	}
+	e.replied = true
+	s.mu.Unlock()
+
+	e.notify(reply)
+}
+
+// expire is called by the timer wheel once the echo's deadline has passed.
+func (s *socket) expire(e *echo) {
+	s.mu.Lock()
+	if s.pending[e.key] == e {
+		delete(s.pending, e.key)
+	}
+	replied := e.replied
+	s.mu.Unlock()
+
+	if !replied {
+		e.notify(echoReply{event: echoTimedOut})
+	}
+}
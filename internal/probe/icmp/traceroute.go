@@ -0,0 +1,471 @@
+package icmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	klog "k8s.io/klog/v2"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/metrics"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/probe"
+)
+
+// tracerouteProbeSize is the payload of icmp and udp probes, as sent by
+// traceroute(8).
+const tracerouteProbeSize = 32
+
+// TracerouteProbe sends probes with increasing TTLs to a host, like mtr, and
+// reports the round-trip time and loss of every hop on the path. The ICMP
+// errors routers answer with are read from the shared raw socket.
+type TracerouteProbe struct {
+	target   config.TracerouteTarget
+	metrics  *metrics.Traceroute
+	interval time.Duration
+
+	// flowsMu is held while the flows are probed. Flows bind fixed source
+	// ports, so a run still going on at the next tick skips that tick's.
+	flowsMu sync.Mutex
+
+	mu         sync.Mutex
+	ran        bool
+	path       []string
+	reached    bool
+	rttSeries  map[hopSeries]struct{}
+	lossSeries map[hopSeries]struct{}
+}
+
+type hopSeries struct {
+	hop     string
+	address string
+}
+
+// hopResult is the answer to one probe. from is invalid if none came.
+type hopResult struct {
+	from    netip.Addr
+	rtt     time.Duration
+	reached bool
+}
+
+func NewTraceroute(target config.TracerouteTarget, m *metrics.Traceroute) *TracerouteProbe {
+	return &TracerouteProbe{
+		target:     target,
+		metrics:    m,
+		interval:   probe.IntervalFromRPS(target.RPS),
+		rttSeries:  make(map[hopSeries]struct{}),
+		lossSeries: make(map[hopSeries]struct{}),
+	}
+}
+
+func (p *TracerouteProbe) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			go p.probeOnce(ctx)
+		}
+	}
+}
+
+// probeOnce sends Count rounds of probes. Every hop of a round is probed at
+// once, and later rounds stop at the closest hop the host answered from. The
+// probes of each flow are sent meanwhile.
+func (p *TracerouteProbe) probeOnce(ctx context.Context) {
+	dst, sock, err := p.resolve()
+	if err != nil {
+		klog.V(4).Infof("traceroute probe failure for host %s: %v", p.target.Host, err)
+		return
+	}
+
+	if p.target.Flows > 0 {
+		if p.flowsMu.TryLock() {
+			var flows [][]hopResult
+			flowsDone := make(chan struct{})
+			go func() {
+				defer close(flowsDone)
+				flows = p.probeFlows(ctx, sock, dst)
+			}()
+			defer func() {
+				<-flowsDone
+				if ctx.Err() == nil {
+					p.observeFlows(flows)
+				}
+				p.flowsMu.Unlock()
+			}()
+		} else {
+			klog.V(4).Infof("traceroute probe for host %s: flows of the previous run still going, skipping them", p.target.Host)
+		}
+	}
+
+	results := make([][]hopResult, p.target.MaxHops)
+	for i := range results {
+		results[i] = make([]hopResult, p.target.Count)
+	}
+	maxTTL := p.target.MaxHops
+	for round := 0; round < p.target.Count; round++ {
+		var wg sync.WaitGroup
+		for ttl := 1; ttl <= maxTTL; ttl++ {
+			wg.Add(1)
+			go func(ttl int) {
+				defer wg.Done()
+				r, err := p.probeHop(ctx, sock, dst, ttl, 0)
+				if err != nil && ctx.Err() == nil {
+					klog.V(4).Infof("traceroute probe failure for host %s ttl %d: %v", p.target.Host, ttl, err)
+				}
+				results[ttl-1][round] = r
+			}(ttl)
+		}
+		wg.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+
+		for ttl := 1; ttl <= maxTTL; ttl++ {
+			if results[ttl-1][round].reached {
+				maxTTL = ttl
+				break
+			}
+		}
+	}
+
+	p.observe(results)
+}
+
+func (p *TracerouteProbe) resolve() (netip.Addr, *socket, error) {
+	addr, err := net.ResolveIPAddr("ip", p.target.Host)
+	if err != nil {
+		return netip.Addr{}, nil, err
+	}
+	dst, ok := netip.AddrFromSlice(addr.IP)
+	if !ok {
+		return netip.Addr{}, nil, fmt.Errorf("invalid address %s", addr)
+	}
+	dst = dst.Unmap().WithZone(addr.Zone)
+
+	sock, err := socketTo(dst, true, false, 0, p.target.SocketOptions)
+	if err != nil {
+		return netip.Addr{}, nil, err
+	}
+	return dst, sock, nil
+}
+
+// probeFlows sends Count probes to the host over each flow, one at a time
+// since the probes of a flow share its source port.
+func (p *TracerouteProbe) probeFlows(ctx context.Context, sock *socket, dst netip.Addr) [][]hopResult {
+	results := make([][]hopResult, p.target.Flows)
+	var wg sync.WaitGroup
+	for flow := range results {
+		wg.Add(1)
+		go func(flow int) {
+			defer wg.Done()
+			flowSock, port := sock, p.target.SourcePort+flow
+			if p.target.Protocol == config.TracerouteProtocolICMP {
+				var err error
+				flowSock, err = socketTo(dst, true, false, flow, p.target.SocketOptions)
+				if err != nil {
+					klog.V(4).Infof("traceroute probe failure for host %s flow %d: %v", p.target.Host, flow, err)
+					return
+				}
+			}
+			for i := 0; i < p.target.Count && ctx.Err() == nil; i++ {
+				r, err := p.probeHop(ctx, flowSock, dst, p.target.MaxHops, port)
+				if err != nil {
+					// The probe never left, e.g. the source port is taken;
+					// that says nothing about the path, so it is not loss.
+					if ctx.Err() == nil {
+						klog.Errorf("traceroute probe for host %s flow %d (source port %d): %v", p.target.Host, flow, port, err)
+					}
+					return
+				}
+				results[flow] = append(results[flow], r)
+			}
+		}(flow)
+	}
+	wg.Wait()
+	return results
+}
+
+// probeHop sends a probe with the given TTL. port is the source port of udp
+// and tcp probes, or 0 for any. An error means the probe could not be sent.
+func (p *TracerouteProbe) probeHop(ctx context.Context, sock *socket, dst netip.Addr, ttl, port int) (hopResult, error) {
+	var (
+		r   echoReply
+		err error
+	)
+	switch p.target.Protocol {
+	case config.TracerouteProtocolUDP:
+		r, err = p.probeUDP(ctx, sock, dst, ttl, port)
+	case config.TracerouteProtocolTCP:
+		r, err = p.probeTCP(ctx, sock, dst, ttl, port)
+	default:
+		r, err = sock.ping(ctx, dst, ttl, tracerouteProbeSize, p.target.Timeout)
+	}
+	if err != nil {
+		return hopResult{}, err
+	}
+	if r.event == echoTimedOut {
+		return hopResult{}, nil
+	}
+	return hopResult{
+		from:    r.from,
+		rtt:     r.rtt,
+		reached: r.from.WithZone("") == dst.WithZone(""),
+	}, nil
+}
+
+// probeUDP sends a datagram from a socket of its own, so the source port
+// identifies it in the ICMP errors. The host answers with port unreachable.
+func (p *TracerouteProbe) probeUDP(ctx context.Context, sock *socket, dst netip.Addr, ttl, port int) (echoReply, error) {
+	network := "udp4"
+	if dst.Is6() {
+		network = "udp6"
+	}
+	lc := net.ListenConfig{Control: probe.Control(p.target.SocketOptions)}
+	pc, err := lc.ListenPacket(ctx, network, net.JoinHostPort(p.target.SourceIP, strconv.Itoa(port)))
+	if err != nil {
+		return echoReply{}, err
+	}
+	conn := pc.(*net.UDPConn)
+	defer conn.Close()
+
+	if dst.Is6() {
+		err = ipv6.NewConn(conn).SetHopLimit(ttl)
+	} else {
+		err = ipv4.NewConn(conn).SetTTL(ttl)
+	}
+	if err != nil {
+		return echoReply{}, fmt.Errorf("set ttl: %w", err)
+	}
+
+	w := newReplyWaiter()
+	key := probeKey{proto: protocolUDP, id: uint16(conn.LocalAddr().(*net.UDPAddr).Port)}
+	e, err := sock.expect(key, dst, p.target.Timeout, w.notify)
+	if err != nil {
+		return echoReply{}, err
+	}
+	to := net.UDPAddrFromAddrPort(netip.AddrPortFrom(dst, uint16(p.target.Port)))
+	if _, err := conn.WriteToUDP(make([]byte, tracerouteProbeSize), to); err != nil {
+		sock.forget(e)
+		return echoReply{}, err
+	}
+	return w.wait(ctx)
+}
+
+// probeTCP sends a SYN by connecting. The host answers by accepting or
+// refusing the connection, and routers with ICMP errors about the SYN.
+func (p *TracerouteProbe) probeTCP(ctx context.Context, sock *socket, dst netip.Addr, ttl, port int) (echoReply, error) {
+	network := "tcp4"
+	if dst.Is6() {
+		network = "tcp6"
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.target.Timeout)
+	defer cancel()
+
+	var (
+		w      = newReplyWaiter()
+		e      *echo
+		dialed = make(chan error, 1)
+	)
+	go func() {
+		address := netip.AddrPortFrom(dst, uint16(p.target.Port)).String()
+		conn, err := dialTTL(ctx, network, address, ttl, port, p.target.SocketOptions, func(port int) error {
+			var err error
+			e, err = sock.expect(probeKey{proto: protocolTCP, id: uint16(port)}, dst, p.target.Timeout, w.notify)
+			return err
+		})
+		if conn != nil {
+			// Reset rather than close, so the port of a flow is not held
+			// in TIME_WAIT.
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+		}
+		dialed <- err
+	}()
+
+	select {
+	case r := <-w:
+		return r, nil
+	case err := <-dialed:
+		if e == nil {
+			return echoReply{}, err
+		}
+		if err == nil || errors.Is(err, syscall.ECONNREFUSED) {
+			sock.forget(e)
+			return echoReply{event: echoReplied, rtt: time.Since(e.sent), from: dst}, nil
+		}
+		// The SYN got no answer from the host; the ICMP error, if any, or
+		// the timeout of the expectation follows.
+		return w.wait(context.Background())
+	}
+}
+
+// observe exports the hops up to the host, or up to the last hop that
+// answered if the host did not.
+func (p *TracerouteProbe) observe(results [][]hopResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hops, reached := len(results), false
+	for i, probes := range results {
+		for _, r := range probes {
+			reached = reached || r.reached
+		}
+		if reached {
+			hops = i + 1
+			break
+		}
+	}
+	if !reached {
+		for hops > 0 && !answered(results[hops-1]) {
+			hops--
+		}
+	}
+
+	type addrStats struct {
+		replies int
+		sum     time.Duration
+	}
+	var (
+		path       = make([]string, hops)
+		rttSeries  = make(map[hopSeries]struct{})
+		lossSeries = make(map[hopSeries]struct{})
+	)
+	for i := 0; i < hops; i++ {
+		hop := strconv.Itoa(i + 1)
+		byAddr := make(map[string]*addrStats)
+		replies := 0
+		for _, r := range results[i] {
+			if !r.from.IsValid() {
+				continue
+			}
+			replies++
+			s, ok := byAddr[r.from.String()]
+			if !ok {
+				s = &addrStats{}
+				byAddr[r.from.String()] = s
+			}
+			s.replies++
+			s.sum += r.rtt
+		}
+
+		// The hop is named after the address that answered most often.
+		primary, best := "*", 0
+		for addr, s := range byAddr {
+			series := hopSeries{hop: hop, address: addr}
+			p.metrics.HopRTT.With(p.hopLabels(series)).Set((s.sum / time.Duration(s.replies)).Seconds())
+			rttSeries[series] = struct{}{}
+			if s.replies > best || (s.replies == best && addr < primary) {
+				primary, best = addr, s.replies
+			}
+		}
+		path[i] = primary
+
+		series := hopSeries{hop: hop, address: primary}
+		p.metrics.HopLoss.With(p.hopLabels(series)).Set(1 - float64(replies)/float64(len(results[i])))
+		lossSeries[series] = struct{}{}
+	}
+
+	for series := range p.rttSeries {
+		if _, ok := rttSeries[series]; !ok {
+			p.metrics.HopRTT.Delete(p.hopLabels(series))
+		}
+	}
+	for series := range p.lossSeries {
+		if _, ok := lossSeries[series]; !ok {
+			p.metrics.HopLoss.Delete(p.hopLabels(series))
+		}
+	}
+	p.rttSeries, p.lossSeries = rttSeries, lossSeries
+
+	labels := prometheus.Labels{"name": p.target.Name, "host": p.target.Host}
+	p.metrics.Hops.With(labels).Set(float64(hops))
+	if reached {
+		p.metrics.Reached.With(labels).Set(1)
+	} else {
+		p.metrics.Reached.With(labels).Set(0)
+	}
+	changes := p.metrics.PathChanges.With(labels)
+	if p.ran && pathChanged(p.path, p.reached, path, reached) {
+		klog.V(2).Infof("traceroute path to %s changed: %v -> %v", p.target.Host, p.path, path)
+		changes.Inc()
+	}
+	p.ran, p.path, p.reached = true, path, reached
+}
+
+// observeFlows exports the loss and latency to the host of every flow, so a
+// failing ECMP path shows up as one bad flow.
+func (p *TracerouteProbe) observeFlows(flows [][]hopResult) {
+	for flow, results := range flows {
+		if len(results) == 0 {
+			continue
+		}
+		labels := prometheus.Labels{
+			"name": p.target.Name,
+			"host": p.target.Host,
+			"flow": strconv.Itoa(flow),
+		}
+		var (
+			reached int
+			sum     time.Duration
+		)
+		for _, r := range results {
+			if r.reached {
+				reached++
+				sum += r.rtt
+			}
+		}
+		p.metrics.FlowLoss.With(labels).Set(1 - float64(reached)/float64(len(results)))
+		if reached == 0 {
+			p.metrics.FlowRTT.Delete(labels)
+			continue
+		}
+		p.metrics.FlowRTT.With(labels).Set((sum / time.Duration(reached)).Seconds())
+	}
+}
+
+func (p *TracerouteProbe) hopLabels(series hopSeries) prometheus.Labels {
+	return prometheus.Labels{
+		"name":    p.target.Name,
+		"host":    p.target.Host,
+		"hop":     series.hop,
+		"address": series.address,
+	}
+}
+
+func answered(probes []hopResult) bool {
+	for _, r := range probes {
+		if r.from.IsValid() {
+			return true
+		}
+	}
+	return false
+}
+
+// pathChanged reports whether a hop answered from another address, or the
+// host moved to another hop. Hops that did not answer in either run are
+// skipped, so loss alone is not a path change.
+func pathChanged(prev []string, prevReached bool, cur []string, curReached bool) bool {
+	if prevReached && curReached && len(prev) != len(cur) {
+		return true
+	}
+	for i := 0; i < len(prev) && i < len(cur); i++ {
+		if prev[i] != "*" && cur[i] != "*" && prev[i] != cur[i] {
+			return true
+		}
+	}
+	return false
+}
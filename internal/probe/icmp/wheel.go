@@ -0,0 +1,76 @@
+package icmp
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	wheelTick  = 10 * time.Millisecond
+	wheelSlots = 512
+)
+
+// timerWheel expires outstanding echoes of every socket from a single
+// goroutine. Deadlines are rounded up to the next tick; echoes further away
+// than one revolution wait the remaining rounds in their slot.
+type timerWheel struct {
+	mu    sync.Mutex
+	slots [wheelSlots][]*echo
+	pos   int
+}
+
+var (
+	wheelOnce sync.Once
+	wheelInst *timerWheel
+)
+
+func sharedWheel() *timerWheel {
+	wheelOnce.Do(func() {
+		wheelInst = &timerWheel{}
+		go wheelInst.run()
+	})
+	return wheelInst
+}
+
+func (w *timerWheel) add(e *echo) {
+	ticks := int((time.Until(e.deadline) + wheelTick - 1) / wheelTick)
+	if ticks < 1 {
+		ticks = 1
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	e.rounds = (ticks - 1) / wheelSlots
+	slot := (w.pos + ticks) % wheelSlots
+	w.slots[slot] = append(w.slots[slot], e)
+}
+
+func (w *timerWheel) run() {
+	ticker := time.NewTicker(wheelTick)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, e := range w.advance() {
+			e.sock.expire(e)
+		}
+	}
+}
+
+// advance moves to the next slot and returns the echoes that are due.
+func (w *timerWheel) advance() []*echo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pos = (w.pos + 1) % wheelSlots
+	var due, waiting []*echo
+	for _, e := range w.slots[w.pos] {
+		if e.rounds > 0 {
+			e.rounds--
+			waiting = append(waiting, e)
+			continue
+		}
+		due = append(due, e)
+	}
+	w.slots[w.pos] = waiting
+	return due
+}
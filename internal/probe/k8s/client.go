@@ -4,17 +4,62 @@ import (
 	"fmt"
 
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/metrics"
 )
 
-func NewClient() (*kubernetes.Clientset, error) {
-	cfg, err := rest.InClusterConfig()
+// Client talks to the API server of one cluster. Its metadata client feeds
+// the informers, which only need to count objects and so skip their specs.
+type Client struct {
+	name      string
+	clientset *kubernetes.Clientset
+	metadata  metadata.Interface
+}
+
+// NewClient builds a client for the cluster from its kubeconfig and context,
+// following the standard loading rules for whatever is left empty, and falling
+// back to the in-cluster service account when no kubeconfig is found. The
+// unnamed top-level cluster is named after its context, or "default" when it
+// has none.
+func NewClient(cluster config.K8SCluster) (*Client, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = cluster.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: cluster.Context}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+	cfg, err := clientConfig.ClientConfig()
 	if err != nil {
-		return nil, fmt.Errorf("create in-cluster config: %w", err)
+		return nil, fmt.Errorf("load client config: %w", err)
+	}
+
+	name := cluster.Name
+	if name == "" {
+		name = cluster.Context
+	}
+	if name == "" {
+		if raw, err := clientConfig.RawConfig(); err == nil {
+			name = raw.CurrentContext
+		}
 	}
+	if name == "" {
+		name = "default"
+	}
+	metrics.InstrumentK8SConfig(cfg, name)
+
 	clientset, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("create kubernetes client: %w", err)
 	}
-	return clientset, nil
+	metadataClient, err := metadata.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("create metadata client: %w", err)
+	}
+	return &Client{name: name, clientset: clientset, metadata: metadataClient}, nil
+}
+
+// Name returns the cluster label of the client's series.
+func (c *Client) Name() string {
+	return c.name
 }
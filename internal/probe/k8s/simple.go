@@ -2,11 +2,16 @@ package k8s
 
 import (
 	"context"
+	"errors"
+	"io"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
 	klog "k8s.io/klog/v2"
 
 	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
@@ -14,23 +19,38 @@ import (
 	"gitlab.snapp.ir/snappcloud/health_exporter/internal/probe"
 )
 
+// SimpleProbe counts the pods of a namespace from an informer's cache, so the
+// API server only streams changes, and on every tick measures the API latency
+// with a list limited to a single pod.
 type SimpleProbe struct {
-	client    *kubernetes.Clientset
+	client    *Client
 	metrics   *metrics.K8S
 	interval  time.Duration
+	timeout   time.Duration
+	cluster   string
 	namespace string
 }
 
-func NewSimpleProbe(client *kubernetes.Clientset, target config.K8SSimpleProbe, m *metrics.K8S) *SimpleProbe {
+func NewSimpleProbe(client *Client, target config.K8SSimpleProbe, m *metrics.K8S) *SimpleProbe {
 	return &SimpleProbe{
 		client:    client,
 		metrics:   m,
 		interval:  probe.IntervalFromRPS(target.RPS),
+		timeout:   target.Timeout,
+		cluster:   client.Name(),
 		namespace: target.NameSpace,
 	}
 }
 
 func (s *SimpleProbe) Run(ctx context.Context) error {
+	factory := metadatainformer.NewFilteredSharedInformerFactory(s.client.metadata, 0, s.namespace, nil)
+	informer := factory.ForResource(corev1.SchemeGroupVersion.WithResource("pods")).Informer()
+	if err := informer.SetWatchErrorHandler(s.watchError); err != nil {
+		return err
+	}
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+
 	ticker := time.NewTicker(s.interval)
 	defer ticker.Stop()
 
@@ -39,18 +59,53 @@ func (s *SimpleProbe) Run(ctx context.Context) error {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-ticker.C:
-			go s.poll(ctx)
+			if informer.HasSynced() {
+				s.metrics.PodCount.With(s.labels()).Set(float64(len(informer.GetStore().ListKeys())))
+			}
+			s.probeLatency(ctx)
 		}
 	}
 }
 
-func (s *SimpleProbe) poll(ctx context.Context) {
-	pods, err := s.client.CoreV1().Pods(s.namespace).List(ctx, metav1.ListOptions{})
+func (s *SimpleProbe) probeLatency(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	start := time.Now()
+	_, err := s.client.clientset.CoreV1().Pods(s.namespace).List(ctx, metav1.ListOptions{Limit: 1})
+	elapsed := time.Since(start).Seconds()
+
+	result := "k8s_success"
+	switch {
+	case err == nil:
+	case errors.Is(err, context.DeadlineExceeded):
+		result = "timeout"
+	default:
+		result = "k8s_error"
+	}
 	if err != nil {
-		klog.Warningf("k8s probe namespace %s: %v", s.namespace, err)
+		klog.V(4).Infof("k8s probe cluster %q namespace %s: %v", s.cluster, s.namespace, err)
+	}
+
+	labels := s.labels()
+	labels["result"] = result
+	s.metrics.Requests.With(labels).Inc()
+	s.metrics.Durations.With(labels).Observe(elapsed)
+}
+
+// watchError counts the failures of the informer's watch, leaving out the
+// closed and expired watches the API server ends every few minutes.
+func (s *SimpleProbe) watchError(r *cache.Reflector, err error) {
+	cache.DefaultWatchErrorHandler(r, err)
+	if errors.Is(err, io.EOF) || apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
 		return
 	}
-	s.metrics.PodCount.With(prometheus.Labels{
+	s.metrics.WatchErrors.With(s.labels()).Inc()
+}
+
+func (s *SimpleProbe) labels() prometheus.Labels {
+	return prometheus.Labels{
+		"cluster":   s.cluster,
 		"namespace": s.namespace,
-	}).Set(float64(len(pods.Items)))
+	}
 }
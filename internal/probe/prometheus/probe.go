@@ -0,0 +1,185 @@
+package prometheus
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	klog "k8s.io/klog/v2"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/exposition"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/metrics"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/probe"
+)
+
+const acceptHeader = "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5,*/*;q=0.1"
+
+type Probe struct {
+	target     config.PrometheusTarget
+	client     *http.Client
+	assertions []exposition.Assertion
+	metrics    *metrics.Prometheus
+	interval   time.Duration
+}
+
+func New(target config.PrometheusTarget, m *metrics.Prometheus) (*Probe, error) {
+	p := &Probe{
+		target:   target,
+		client:   buildClient(target),
+		metrics:  m,
+		interval: probe.IntervalFromRPS(target.RPS),
+	}
+	for _, expr := range target.Assertions {
+		a, err := exposition.ParseAssertion(expr)
+		if err != nil {
+			return nil, err
+		}
+		p.assertions = append(p.assertions, a)
+	}
+	return p, nil
+}
+
+func (p *Probe) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			go p.probeOnce(ctx)
+		}
+	}
+}
+
+func (p *Probe) probeOnce(ctx context.Context) {
+	stats := p.scrape(ctx)
+	result := stats.result
+
+	if stats.samples != nil {
+		p.metrics.Samples.With(prometheus.Labels{
+			"name": p.target.Name,
+			"url":  p.target.URL,
+		}).Set(float64(len(stats.samples)))
+
+		for _, a := range p.assertions {
+			ok, err := a.Evaluate(stats.samples)
+			value := 0.0
+			if ok {
+				value = 1
+			} else {
+				klog.V(4).Infof("prometheus probe %q: assertion %q failed: %v", p.target.Name, a.Expr, err)
+				result = "assertion_failed"
+			}
+			p.metrics.AssertionSuccess.With(prometheus.Labels{
+				"name":      p.target.Name,
+				"url":       p.target.URL,
+				"assertion": a.Expr,
+			}).Set(value)
+		}
+	}
+
+	labels := prometheus.Labels{
+		"url":         p.target.URL,
+		"name":        p.target.Name,
+		"status_code": strconv.Itoa(stats.statusCode),
+		"result":      result,
+	}
+	p.metrics.Requests.With(labels).Inc()
+	p.metrics.Durations.With(labels).Observe(stats.responseTime)
+}
+
+type scrapeStats struct {
+	statusCode   int
+	responseTime float64
+	result       string
+	samples      []exposition.Sample
+}
+
+func (p *Probe) scrape(ctx context.Context) scrapeStats {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.target.URL, nil)
+	if err != nil {
+		return scrapeStats{result: "request_build_error"}
+	}
+	req.Header.Set("Accept", acceptHeader)
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return scrapeStats{
+			responseTime: time.Since(start).Seconds(),
+			result:       classifyError(err),
+		}
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			klog.V(4).Infof("close scrape response body failed: %v", err)
+		}
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			klog.V(4).Infof("drain scrape response body failed: %v", err)
+		}
+		return scrapeStats{
+			statusCode:   resp.StatusCode,
+			responseTime: time.Since(start).Seconds(),
+			result:       "http_error",
+		}
+	}
+
+	samples, err := exposition.Parse(resp.Body)
+	stats := scrapeStats{
+		statusCode:   resp.StatusCode,
+		responseTime: time.Since(start).Seconds(),
+	}
+	if err != nil {
+		klog.V(4).Infof("prometheus probe %q: parse exposition: %v", p.target.Name, err)
+		stats.result = classifyError(err)
+		if stats.result == "connection_failed" {
+			stats.result = "parse_error"
+		}
+		return stats
+	}
+
+	stats.samples = samples
+	stats.result = "scrape_success"
+	return stats
+}
+
+func classifyError(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		var dnsErr *net.DNSError
+		if errors.As(urlErr.Err, &dnsErr) {
+			return "dns_error"
+		}
+	}
+
+	return "connection_failed"
+}
+
+func buildClient(target config.PrometheusTarget) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if target.TLSSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &http.Client{
+		Timeout:   target.Timeout,
+		Transport: transport,
+	}
+}
@@ -0,0 +1,213 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	klog "k8s.io/klog/v2"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/metrics"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/probe"
+)
+
+type Probe struct {
+	target   config.S3Target
+	endpoint *url.URL
+	client   *http.Client
+	signer   signer
+	metrics  *metrics.S3
+	interval time.Duration
+}
+
+func New(target config.S3Target, m *metrics.S3) (*Probe, error) {
+	endpoint, err := url.Parse(target.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint: %w", err)
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("endpoint %q: scheme and host are required", target.Endpoint)
+	}
+
+	return &Probe{
+		target:   target,
+		endpoint: endpoint,
+		client:   buildClient(target),
+		signer: signer{
+			accessKey: target.AccessKey,
+			secretKey: target.SecretKey,
+			region:    target.Region,
+		},
+		metrics:  m,
+		interval: probe.IntervalFromRPS(target.RPS),
+	}, nil
+}
+
+func (p *Probe) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			go p.probeOnce(ctx)
+		}
+	}
+}
+
+// probeOnce writes a fresh object, reads it back and removes it. The delete
+// is attempted whenever the put succeeded so failed reads don't leave
+// objects behind.
+func (p *Probe) probeOnce(ctx context.Context) {
+	payload := make([]byte, p.target.ObjectSize)
+	if _, err := rand.Read(payload); err != nil {
+		klog.Warningf("s3 probe %q: generate payload: %v", p.target.Name, err)
+		return
+	}
+	key := p.target.KeyPrefix + strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	put := p.do(ctx, http.MethodPut, key, payload)
+	p.observe("put", put)
+	if put.result != "s3_success" {
+		return
+	}
+
+	get := p.do(ctx, http.MethodGet, key, nil)
+	if get.result == "s3_success" && !bytes.Equal(get.body, payload) {
+		get.result = "content_mismatch"
+	}
+	p.observe("get", get)
+
+	p.observe("delete", p.do(ctx, http.MethodDelete, key, nil))
+}
+
+func (p *Probe) observe(operation string, stats s3OpStats) {
+	labels := prometheus.Labels{
+		"name":        p.target.Name,
+		"operation":   operation,
+		"status_code": strconv.Itoa(stats.statusCode),
+		"result":      stats.result,
+		"endpoint":    p.target.Endpoint,
+		"bucket":      p.target.Bucket,
+	}
+	p.metrics.Requests.With(labels).Inc()
+	p.metrics.Durations.With(labels).Observe(stats.responseTime)
+}
+
+type s3OpStats struct {
+	statusCode   int
+	responseTime float64
+	result       string
+	body         []byte
+}
+
+func (p *Probe) do(ctx context.Context, method, key string, payload []byte) s3OpStats {
+	req, err := http.NewRequestWithContext(ctx, method, p.objectURL(key).String(), bytes.NewReader(payload))
+	if err != nil {
+		return s3OpStats{result: "request_build_error"}
+	}
+	req.ContentLength = int64(len(payload))
+	p.signer.sign(req, payload, time.Now())
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		klog.V(4).Infof("s3 probe %q: %s %s: %v", p.target.Name, method, key, err)
+		return s3OpStats{
+			responseTime: time.Since(start).Seconds(),
+			result:       classifyError(err),
+		}
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			klog.V(4).Infof("close s3 response body failed: %v", err)
+		}
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	stats := s3OpStats{
+		statusCode:   resp.StatusCode,
+		responseTime: time.Since(start).Seconds(),
+		body:         body,
+	}
+	if err != nil {
+		stats.result = classifyError(err)
+		return stats
+	}
+	stats.result = classifyStatus(resp.StatusCode)
+	if stats.result != "s3_success" {
+		klog.V(4).Infof("s3 probe %q: %s %s: status %d: %s", p.target.Name, method, key, resp.StatusCode, body)
+	}
+	return stats
+}
+
+func (p *Probe) objectURL(key string) *url.URL {
+	u := *p.endpoint
+	basePath := strings.TrimSuffix(u.Path, "/")
+	if p.target.VirtualHostedStyle {
+		u.Host = p.target.Bucket + "." + u.Host
+		u.Path = basePath + "/" + key
+	} else {
+		u.Path = basePath + "/" + p.target.Bucket + "/" + key
+	}
+	// Send the path exactly as it was signed.
+	u.RawPath = uriEncode(u.Path, false)
+	return &u
+}
+
+func classifyStatus(code int) string {
+	switch {
+	case code >= 200 && code < 300:
+		return "s3_success"
+	case code == http.StatusForbidden:
+		return "access_denied"
+	case code >= 400 && code < 500:
+		return "http_client_error"
+	case code >= 500:
+		return "http_server_error"
+	default:
+		return "http_other_error"
+	}
+}
+
+func classifyError(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		var dnsErr *net.DNSError
+		if errors.As(urlErr.Err, &dnsErr) {
+			return "dns_error"
+		}
+	}
+
+	return "connection_failed"
+}
+
+func buildClient(target config.S3Target) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if target.TLSSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &http.Client{
+		Timeout:   target.Timeout,
+		Transport: transport,
+	}
+}
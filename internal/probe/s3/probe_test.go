@@ -0,0 +1,167 @@
+package s3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/metrics"
+)
+
+// fakeS3 is an in-memory, path-style S3 stand-in that checks every request
+// is signed and records the operations it served.
+type fakeS3 struct {
+	t *testing.T
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	methods []string
+	// getStatus and getBody, when set, replace the response to GET.
+	getStatus int
+	getBody   []byte
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{t: t, objects: map[string][]byte{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		f.t.Errorf("read request body: %v", err)
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, signingAlgorithm+" Credential=AKID/") ||
+		!strings.Contains(auth, "SignedHeaders=host;x-amz-content-sha256;x-amz-date") ||
+		!strings.Contains(auth, "Signature=") {
+		f.t.Errorf("%s %s: unexpected Authorization %q", r.Method, r.URL.Path, auth)
+	}
+	if _, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date")); err != nil {
+		f.t.Errorf("%s %s: bad X-Amz-Date: %v", r.Method, r.URL.Path, err)
+	}
+	sum := sha256.Sum256(body)
+	if got := r.Header.Get("X-Amz-Content-Sha256"); got != hex.EncodeToString(sum[:]) {
+		f.t.Errorf("%s %s: X-Amz-Content-Sha256 %q does not match the body", r.Method, r.URL.Path, got)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.methods = append(f.methods, r.Method)
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[r.URL.Path] = body
+	case http.MethodGet:
+		if f.getStatus != 0 {
+			w.WriteHeader(f.getStatus)
+			_, _ = w.Write(f.getBody)
+			return
+		}
+		obj, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(obj)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestProbeOnce(t *testing.T) {
+	m := metrics.NewS3(prometheus.NewRegistry())
+
+	tests := []struct {
+		name       string
+		getStatus  int
+		getBody    []byte
+		getResult  string
+		getCode    string
+		wantMethod []string
+	}{
+		{
+			name:       "round-trip",
+			getResult:  "s3_success",
+			getCode:    "200",
+			wantMethod: []string{"PUT", "GET", "DELETE"},
+		},
+		{
+			name:       "content-mismatch",
+			getStatus:  http.StatusOK,
+			getBody:    []byte("not what was written"),
+			getResult:  "content_mismatch",
+			getCode:    "200",
+			wantMethod: []string{"PUT", "GET", "DELETE"},
+		},
+		{
+			name:       "failed-get",
+			getStatus:  http.StatusInternalServerError,
+			getResult:  "http_server_error",
+			getCode:    "500",
+			wantMethod: []string{"PUT", "GET", "DELETE"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, srv := newFakeS3(t)
+			f.getStatus, f.getBody = tt.getStatus, tt.getBody
+
+			p, err := New(config.S3Target{
+				Name:       tt.name,
+				Endpoint:   srv.URL,
+				Region:     "us-east-1",
+				Bucket:     "health",
+				KeyPrefix:  "probes/",
+				AccessKey:  "AKID",
+				SecretKey:  "SECRET",
+				ObjectSize: 64,
+				RPS:        1,
+				Timeout:    time.Second,
+			}, m)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			p.probeOnce(context.Background())
+
+			if strings.Join(f.methods, ",") != strings.Join(tt.wantMethod, ",") {
+				t.Errorf("methods = %v, want %v", f.methods, tt.wantMethod)
+			}
+			if len(f.objects) != 0 {
+				t.Errorf("%d objects left behind", len(f.objects))
+			}
+
+			for _, op := range []struct{ operation, code, result string }{
+				{"put", "200", "s3_success"},
+				{"get", tt.getCode, tt.getResult},
+				{"delete", "204", "s3_success"},
+			} {
+				got := testutil.ToFloat64(m.Requests.With(prometheus.Labels{
+					"name":        tt.name,
+					"operation":   op.operation,
+					"status_code": op.code,
+					"result":      op.result,
+					"endpoint":    srv.URL,
+					"bucket":      "health",
+				}))
+				if got != 1 {
+					t.Errorf("%s %s requests = %v, want 1", op.operation, op.result, got)
+				}
+			}
+		})
+	}
+}
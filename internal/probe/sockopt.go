@@ -0,0 +1,16 @@
+package probe
+
+import (
+	"net"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+)
+
+// Dialer returns a dialer whose connections are marked and bound as opts ask.
+func Dialer(opts config.SocketOptions) *net.Dialer {
+	d := &net.Dialer{Control: Control(opts)}
+	if opts.SourceIP != "" {
+		d.LocalAddr = &net.TCPAddr{IP: net.ParseIP(opts.SourceIP)}
+	}
+	return d
+}
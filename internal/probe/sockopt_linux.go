@@ -0,0 +1,52 @@
+//go:build linux
+
+package probe
+
+import (
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+)
+
+// Control returns a net.Dialer or net.ListenConfig Control function that
+// applies the TOS and interface of opts, or nil if neither is set. The source
+// address is up to the caller, as it is bound rather than set.
+func Control(opts config.SocketOptions) func(network, address string, c syscall.RawConn) error {
+	tos := opts.TrafficClass()
+	if tos == 0 && opts.Interface == "" {
+		return nil
+	}
+	return func(network, _ string, c syscall.RawConn) error {
+		var serr error
+		if err := c.Control(func(fd uintptr) {
+			serr = setSocketOptions(int(fd), strings.HasSuffix(network, "6"), tos, opts.Interface)
+		}); err != nil {
+			return err
+		}
+		return serr
+	}
+}
+
+func setSocketOptions(fd int, ipv6 bool, tos int, iface string) error {
+	if tos != 0 {
+		var err error
+		if ipv6 {
+			err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos)
+		} else {
+			err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TOS, tos)
+		}
+		if err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if iface != "" {
+		if err := unix.BindToDevice(fd, iface); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	return nil
+}
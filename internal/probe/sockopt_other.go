@@ -0,0 +1,21 @@
+//go:build !linux
+
+package probe
+
+import (
+	"errors"
+	"syscall"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+)
+
+// Control returns a net.Dialer or net.ListenConfig Control function that
+// applies the TOS and interface of opts, or nil if neither is set.
+func Control(opts config.SocketOptions) func(network, address string, c syscall.RawConn) error {
+	if opts.TrafficClass() == 0 && opts.Interface == "" {
+		return nil
+	}
+	return func(string, string, syscall.RawConn) error {
+		return errors.New("tos, dscp and interface are only supported on linux")
+	}
+}
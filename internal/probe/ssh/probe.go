@@ -0,0 +1,250 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/ssh"
+	klog "k8s.io/klog/v2"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/metrics"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/probe"
+)
+
+var errHostKeyMismatch = errors.New("host key fingerprint mismatch")
+
+type Probe struct {
+	target   config.SSHTarget
+	signer   ssh.Signer
+	metrics  *metrics.SSH
+	interval time.Duration
+	address  string
+
+	mu       sync.Mutex
+	lastInfo prometheus.Labels
+}
+
+func New(target config.SSHTarget, m *metrics.SSH) (*Probe, error) {
+	p := &Probe{
+		target:   target,
+		metrics:  m,
+		interval: probe.IntervalFromRPS(target.RPS),
+		address:  net.JoinHostPort(target.Host, strconv.Itoa(target.Port)),
+	}
+
+	if target.PrivateKeyFile != "" {
+		key, err := os.ReadFile(target.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read private key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("parse private key: %w", err)
+		}
+		p.signer = signer
+	}
+
+	return p, nil
+}
+
+func (p *Probe) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			go p.probeOnce(ctx)
+		}
+	}
+}
+
+func (p *Probe) probeOnce(ctx context.Context) {
+	stats := p.sendRequest(ctx)
+	if stats.err != nil {
+		klog.V(4).Infof("ssh probe failure for %s: %v", p.address, stats.err)
+	}
+
+	labels := prometheus.Labels{
+		"host":   p.address,
+		"name":   p.target.Name,
+		"result": stats.result,
+	}
+
+	p.metrics.Requests.With(labels).Inc()
+	p.metrics.Durations.With(labels).Observe(stats.responseTime)
+
+	if stats.version != "" {
+		p.setServerInfo(stats.version, stats.fingerprint)
+	}
+}
+
+func (p *Probe) setServerInfo(version, fingerprint string) {
+	labels := prometheus.Labels{
+		"host":                 p.address,
+		"name":                 p.target.Name,
+		"version":              version,
+		"host_key_fingerprint": fingerprint,
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lastInfo != nil {
+		p.metrics.ServerInfo.Delete(p.lastInfo)
+	}
+	p.metrics.ServerInfo.With(labels).Set(1)
+	p.lastInfo = labels
+}
+
+type sshProbeStats struct {
+	responseTime float64
+	result       string
+	version      string
+	fingerprint  string
+	err          error
+}
+
+func (p *Probe) sendRequest(ctx context.Context) sshProbeStats {
+	start := time.Now()
+	dialer := probe.Dialer(p.target.SocketOptions)
+	dialer.Timeout = p.target.Timeout
+	conn, err := dialer.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		return sshProbeStats{
+			responseTime: time.Since(start).Seconds(),
+			result:       classifyError(err),
+			err:          err,
+		}
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(start.Add(p.target.Timeout)); err != nil {
+		klog.V(4).Infof("set ssh deadline failed: %v", err)
+	}
+
+	bc := &bannerConn{Conn: conn}
+	var fingerprint string
+	hostKeyVerified := false
+
+	clientCfg := &ssh.ClientConfig{
+		User:    p.target.User,
+		Timeout: p.target.Timeout,
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			fingerprint = ssh.FingerprintSHA256(key)
+			if !matchFingerprint(p.target.HostKeyFingerprint, key) {
+				return errHostKeyMismatch
+			}
+			hostKeyVerified = true
+			return nil
+		},
+	}
+	if p.signer != nil {
+		clientCfg.Auth = []ssh.AuthMethod{ssh.PublicKeys(p.signer)}
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(bc, p.address, clientCfg)
+	stats := sshProbeStats{
+		responseTime: time.Since(start).Seconds(),
+		version:      bc.version(),
+		fingerprint:  fingerprint,
+		err:          err,
+	}
+
+	switch {
+	case err == nil:
+		client := ssh.NewClient(sshConn, chans, reqs)
+		if err := client.Close(); err != nil {
+			klog.V(4).Infof("close ssh client failed: %v", err)
+		}
+		stats.result = "ssh_success"
+	case errors.Is(err, errHostKeyMismatch):
+		stats.result = "host_key_mismatch"
+	case hostKeyVerified && p.signer == nil:
+		// No credentials were configured, so the handshake is only expected
+		// to get as far as host key verification.
+		stats.result = "ssh_success"
+		stats.err = nil
+	case hostKeyVerified:
+		stats.result = "auth_failed"
+	default:
+		stats.result = classifyError(err)
+		if stats.result == "connection_failed" {
+			stats.result = "handshake_failed"
+		}
+	}
+
+	return stats
+}
+
+func matchFingerprint(expected string, key ssh.PublicKey) bool {
+	if expected == "" {
+		return true
+	}
+	if strings.HasPrefix(expected, "SHA256:") {
+		return expected == ssh.FingerprintSHA256(key)
+	}
+	return strings.EqualFold(strings.TrimPrefix(expected, "MD5:"), ssh.FingerprintLegacyMD5(key))
+}
+
+func classifyError(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "dns_error"
+	}
+	return "connection_failed"
+}
+
+// bannerConn records the identification line the server sends before the key
+// exchange, which golang.org/x/crypto/ssh does not expose on failed handshakes.
+type bannerConn struct {
+	net.Conn
+	buf  []byte
+	done bool
+}
+
+func (c *bannerConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if !c.done && n > 0 {
+		c.buf = append(c.buf, b[:n]...)
+		for {
+			i := bytes.IndexByte(c.buf, '\n')
+			if i < 0 {
+				break
+			}
+			line := c.buf[:i]
+			c.buf = c.buf[i+1:]
+			if bytes.HasPrefix(line, []byte("SSH-")) {
+				c.buf = bytes.TrimRight(line, "\r")
+				c.done = true
+				break
+			}
+		}
+		if !c.done && len(c.buf) > 255 {
+			c.buf = c.buf[:0]
+		}
+	}
+	return n, err
+}
+
+func (c *bannerConn) version() string {
+	if !c.done {
+		return ""
+	}
+	return string(c.buf)
+}
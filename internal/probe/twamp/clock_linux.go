@@ -0,0 +1,19 @@
+package twamp
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// clockState asks the kernel whether NTP has synchronized the system clock and
+// how far off it estimates it to be.
+func clockState() (bool, time.Duration) {
+	var tx unix.Timex
+	state, err := unix.Adjtimex(&tx)
+	if err != nil {
+		return false, time.Second
+	}
+	synced := state != unix.TIME_ERROR && tx.Status&unix.STA_UNSYNC == 0
+	return synced, time.Duration(tx.Esterror) * time.Microsecond
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package twamp
+
+import "time"
+
+// clockState cannot tell whether the clock is synchronized outside of Linux,
+// so the clock_synchronized option has to say so.
+func clockState() (bool, time.Duration) {
+	return false, time.Second
+}
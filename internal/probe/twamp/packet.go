@@ -0,0 +1,120 @@
+package twamp
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Test packets use the unauthenticated formats of RFC 5357 section 4.1.2 and
+// 4.2.1. Senders pad their packets to the size of a reflected one so that the
+// reflector never sends more than it received.
+const (
+	packetSize    = 41
+	maxPacketSize = 1472
+
+	// ntpEpochOffset is the number of seconds between 1900 and 1970.
+	ntpEpochOffset = 2208988800
+)
+
+type senderPacket struct {
+	seq      uint32
+	sent     timestamp
+	estimate errorEstimate
+}
+
+func (p senderPacket) marshal() []byte {
+	b := make([]byte, packetSize)
+	binary.BigEndian.PutUint32(b[0:4], p.seq)
+	binary.BigEndian.PutUint64(b[4:12], uint64(p.sent))
+	binary.BigEndian.PutUint16(b[12:14], uint16(p.estimate))
+	return b
+}
+
+func unmarshalSender(b []byte) senderPacket {
+	return senderPacket{
+		seq:      binary.BigEndian.Uint32(b[0:4]),
+		sent:     timestamp(binary.BigEndian.Uint64(b[4:12])),
+		estimate: errorEstimate(binary.BigEndian.Uint16(b[12:14])),
+	}
+}
+
+type reflectorPacket struct {
+	seq      uint32
+	sent     timestamp
+	estimate errorEstimate
+	received timestamp
+	sender   senderPacket
+	ttl      uint8
+}
+
+// marshal writes the packet over the start of b, which must hold at least
+// packetSize bytes, and leaves any padding after it as it is.
+func (p reflectorPacket) marshal(b []byte) {
+	binary.BigEndian.PutUint32(b[0:4], p.seq)
+	binary.BigEndian.PutUint64(b[4:12], uint64(p.sent))
+	binary.BigEndian.PutUint16(b[12:14], uint16(p.estimate))
+	binary.BigEndian.PutUint16(b[14:16], 0)
+	binary.BigEndian.PutUint64(b[16:24], uint64(p.received))
+	binary.BigEndian.PutUint32(b[24:28], p.sender.seq)
+	binary.BigEndian.PutUint64(b[28:36], uint64(p.sender.sent))
+	binary.BigEndian.PutUint16(b[36:38], uint16(p.sender.estimate))
+	binary.BigEndian.PutUint16(b[38:40], 0)
+	b[40] = p.ttl
+}
+
+func unmarshalReflector(b []byte) reflectorPacket {
+	return reflectorPacket{
+		seq:      binary.BigEndian.Uint32(b[0:4]),
+		sent:     timestamp(binary.BigEndian.Uint64(b[4:12])),
+		estimate: errorEstimate(binary.BigEndian.Uint16(b[12:14])),
+		received: timestamp(binary.BigEndian.Uint64(b[16:24])),
+		sender: senderPacket{
+			seq:      binary.BigEndian.Uint32(b[24:28]),
+			sent:     timestamp(binary.BigEndian.Uint64(b[28:36])),
+			estimate: errorEstimate(binary.BigEndian.Uint16(b[36:38])),
+		},
+		ttl: b[40],
+	}
+}
+
+// timestamp is an NTP timestamp, seconds since 1900 and a binary fraction of
+// a second. It is kept as it went on the wire, as converting it to a time.Time
+// and back may round it.
+type timestamp uint64
+
+func newTimestamp(t time.Time) timestamp {
+	sec := uint64(t.Unix() + ntpEpochOffset)
+	frac := (uint64(t.Nanosecond()) << 32) / uint64(time.Second)
+	return timestamp(sec<<32 | frac)
+}
+
+func (ts timestamp) time() time.Time {
+	sec := int64(ts>>32) - ntpEpochOffset
+	frac := (uint64(ts&0xffffffff) * uint64(time.Second)) >> 32
+	return time.Unix(sec, int64(frac))
+}
+
+// errorEstimate is the S, Z, Scale and Multiplier fields of RFC 4656 section
+// 4.1.2: the S bit is set when the clock is synchronized to UTC.
+type errorEstimate uint16
+
+func newErrorEstimate(synced bool, err time.Duration) errorEstimate {
+	// The error is Multiplier*2^(Scale-32) seconds and Multiplier may not be
+	// zero, so round up to the smallest error that can be expressed.
+	units := math.Ceil(err.Seconds() * (1 << 32))
+	scale := 0
+	for units > math.MaxUint8 && scale < 63 {
+		units = math.Ceil(units / 2)
+		scale++
+	}
+	e := errorEstimate(scale<<8) | errorEstimate(math.Max(1, math.Min(units, math.MaxUint8)))
+	if synced {
+		e |= 1 << 15
+	}
+	return e
+}
+
+func (e errorEstimate) synced() bool {
+	return e&(1<<15) != 0
+}
@@ -0,0 +1,311 @@
+package twamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	klog "k8s.io/klog/v2"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/metrics"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/probe"
+)
+
+// Probe sends a session of TWAMP-light test packets to a reflector on every
+// tick and measures the round-trip and one-way delays they saw.
+type Probe struct {
+	target   config.TWAMPTarget
+	metrics  *metrics.TWAMP
+	interval time.Duration
+}
+
+func New(target config.TWAMPTarget, m *metrics.TWAMP) *Probe {
+	return &Probe{
+		target:   target,
+		metrics:  m,
+		interval: probe.IntervalFromRPS(target.RPS),
+	}
+}
+
+func (p *Probe) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			go p.probeOnce(ctx)
+		}
+	}
+}
+
+// delays are the timestamps of a reflected packet as T1 to T4 of RFC 5357:
+// sent by us, received and sent by the reflector, and received by us.
+type delays struct {
+	t1, t2, t3, t4 time.Time
+}
+
+func (d delays) rtt() time.Duration {
+	return d.t4.Sub(d.t1) - d.t3.Sub(d.t2)
+}
+
+func (d delays) forward() time.Duration {
+	return d.t2.Sub(d.t1)
+}
+
+func (d delays) backward() time.Duration {
+	return d.t4.Sub(d.t3)
+}
+
+type twampStats struct {
+	// replies is indexed by sequence number, nil for packets never reflected.
+	replies    []*delays
+	sent       int
+	duplicates int
+	reordered  int
+	synced     bool
+	err        error
+}
+
+func (p *Probe) probeOnce(ctx context.Context) {
+	stats := p.sendRequest(ctx)
+	if stats.err != nil {
+		klog.V(4).Infof("twamp probe failure for %s: %v", p.target.Address, stats.err)
+		p.metrics.Requests.With(p.labels("twamp_error")).Inc()
+	}
+
+	var received []delays
+	for _, d := range stats.replies {
+		if d == nil {
+			continue
+		}
+		received = append(received, *d)
+		labels := p.labels("twamp_success")
+		p.metrics.Requests.With(labels).Inc()
+		p.metrics.Durations.With(labels).Observe(d.rtt().Seconds())
+	}
+	if lost := stats.sent - len(received); lost > 0 {
+		p.metrics.Requests.With(p.labels("twamp_timeout")).Add(float64(lost))
+	}
+
+	if stats.sent == 0 {
+		return
+	}
+	labels := prometheus.Labels{
+		"name":    p.target.Name,
+		"address": p.target.Address,
+	}
+	p.metrics.PacketLoss.With(labels).Set(float64(stats.sent-len(received)) / float64(stats.sent))
+	p.metrics.Duplicates.With(labels).Add(float64(stats.duplicates))
+	p.metrics.Reordered.With(labels).Add(float64(stats.reordered))
+	if len(received) == 0 {
+		return
+	}
+	synced := 0.0
+	if stats.synced {
+		synced = 1
+	}
+	p.metrics.ClockSynced.With(labels).Set(synced)
+
+	p.observeDelays(received, delays.rtt, func(stat string) prometheus.Gauge {
+		return p.metrics.RTT.WithLabelValues(p.target.Name, p.target.Address, stat)
+	})
+	p.metrics.Jitter.WithLabelValues(p.target.Name, p.target.Address, "round_trip").Set(jitter(received, delays.rtt).Seconds())
+	// The offset between the two clocks cancels out of the one-way jitter, so
+	// it is exported whether or not they are synchronized.
+	p.metrics.Jitter.WithLabelValues(p.target.Name, p.target.Address, "forward").Set(jitter(received, delays.forward).Seconds())
+	p.metrics.Jitter.WithLabelValues(p.target.Name, p.target.Address, "backward").Set(jitter(received, delays.backward).Seconds())
+
+	for direction, delay := range map[string]func(delays) time.Duration{
+		"forward":  delays.forward,
+		"backward": delays.backward,
+	} {
+		p.observeDelays(received, delay, func(stat string) prometheus.Gauge {
+			labels := prometheus.Labels{
+				"name":      p.target.Name,
+				"address":   p.target.Address,
+				"direction": direction,
+				"stat":      stat,
+			}
+			if !stats.synced {
+				p.metrics.OneWayDelay.Delete(labels)
+				return nil
+			}
+			return p.metrics.OneWayDelay.With(labels)
+		})
+	}
+}
+
+// observeDelays sets the min, avg and max of delay over the received packets
+// on the gauges gauge returns, skipping the ones it returns nil for.
+func (p *Probe) observeDelays(received []delays, delay func(delays) time.Duration, gauge func(stat string) prometheus.Gauge) {
+	minDelay, maxDelay := time.Duration(math.MaxInt64), time.Duration(math.MinInt64)
+	var sum time.Duration
+	for _, d := range received {
+		v := delay(d)
+		minDelay = min(minDelay, v)
+		maxDelay = max(maxDelay, v)
+		sum += v
+	}
+	for stat, v := range map[string]time.Duration{
+		"min": minDelay,
+		"avg": sum / time.Duration(len(received)),
+		"max": maxDelay,
+	} {
+		if g := gauge(stat); g != nil {
+			g.Set(v.Seconds())
+		}
+	}
+}
+
+// jitter is the mean absolute difference between the delays of consecutive
+// received packets, the IPDV of RFC 3393.
+func jitter(received []delays, delay func(delays) time.Duration) time.Duration {
+	if len(received) < 2 {
+		return 0
+	}
+	var sum time.Duration
+	for i := 1; i < len(received); i++ {
+		diff := delay(received[i]) - delay(received[i-1])
+		if diff < 0 {
+			diff = -diff
+		}
+		sum += diff
+	}
+	return sum / time.Duration(len(received)-1)
+}
+
+func (p *Probe) labels(result string) prometheus.Labels {
+	return prometheus.Labels{
+		"name":    p.target.Name,
+		"address": p.target.Address,
+		"result":  result,
+	}
+}
+
+func (p *Probe) sendRequest(ctx context.Context) twampStats {
+	stats := twampStats{replies: make([]*delays, p.target.Count)}
+
+	dst, err := net.ResolveUDPAddr("udp", p.target.Address)
+	if err != nil {
+		stats.err = err
+		return stats
+	}
+	network := "udp4"
+	if dst.IP.To4() == nil {
+		network = "udp6"
+	}
+	// The socket is not connected, as a reflector listening on a wildcard
+	// address may answer from another of its addresses.
+	lc := net.ListenConfig{Control: probe.Control(p.target.SocketOptions)}
+	pc, err := lc.ListenPacket(ctx, network, net.JoinHostPort(p.target.SourceIP, "0"))
+	if err != nil {
+		stats.err = err
+		return stats
+	}
+	conn := pc.(*net.UDPConn)
+	defer conn.Close()
+
+	synced, estimate := clockState()
+	synced = synced || p.target.ClockSynchronized
+	errEstimate := newErrorEstimate(synced, estimate)
+
+	deadline := time.Now().Add(time.Duration(p.target.Count-1)*p.target.Interval + p.target.Timeout)
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		stats.err = err
+		return stats
+	}
+
+	var (
+		mu       sync.Mutex
+		sentAt   = make([]timestamp, p.target.Count)
+		sendErr  error
+		sendDone = make(chan struct{})
+	)
+	go func() {
+		defer close(sendDone)
+		ticker := time.NewTicker(p.target.Interval)
+		defer ticker.Stop()
+		for seq := 0; seq < p.target.Count; seq++ {
+			if seq > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+			sent := newTimestamp(time.Now())
+			b := senderPacket{seq: uint32(seq), sent: sent, estimate: errEstimate}.marshal()
+			mu.Lock()
+			sentAt[seq] = sent
+			stats.sent++
+			mu.Unlock()
+			if _, err := conn.WriteToUDP(b, dst); err != nil {
+				mu.Lock()
+				sendErr = err
+				mu.Unlock()
+				return
+			}
+		}
+	}()
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	buf := make([]byte, maxPacketSize)
+	received, maxSeq := 0, -1
+	allSynced := synced
+	for received < p.target.Count {
+		n, _, err := conn.ReadFromUDP(buf)
+		now := time.Now()
+		if err != nil {
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				stats.err = err
+			}
+			break
+		}
+		if n < packetSize {
+			continue
+		}
+		reply := unmarshalReflector(buf[:n])
+		seq := int(reply.sender.seq)
+		if seq >= p.target.Count {
+			continue
+		}
+		mu.Lock()
+		t1 := sentAt[seq]
+		mu.Unlock()
+		if t1 == 0 || reply.sender.sent != t1 {
+			continue
+		}
+		if stats.replies[seq] != nil {
+			stats.duplicates++
+			continue
+		}
+		if seq < maxSeq {
+			stats.reordered++
+		}
+		maxSeq = max(maxSeq, seq)
+		stats.replies[seq] = &delays{t1: t1.time(), t2: reply.received.time(), t3: reply.sent.time(), t4: now}
+		allSynced = allSynced && reply.estimate.synced()
+		received++
+	}
+	stop()
+	<-sendDone
+
+	stats.synced = allSynced
+	if sendErr != nil && stats.err == nil {
+		stats.err = fmt.Errorf("send: %w", sendErr)
+	}
+	return stats
+}
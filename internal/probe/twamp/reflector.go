@@ -0,0 +1,120 @@
+package twamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	klog "k8s.io/klog/v2"
+
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/config"
+	"gitlab.snapp.ir/snappcloud/health_exporter/internal/metrics"
+)
+
+// Reflector answers the TWAMP-light test packets of other exporters in the
+// stateless mode of RFC 5357 appendix I, copying the sender's sequence number.
+type Reflector struct {
+	cfg     config.ReflectorConfig
+	metrics *metrics.TWAMP
+	allow   []netip.Prefix
+}
+
+func NewReflector(cfg config.ReflectorConfig, m *metrics.TWAMP) (*Reflector, error) {
+	r := &Reflector{
+		cfg:     cfg,
+		metrics: m,
+	}
+	for _, ip := range cfg.Allow {
+		prefix, err := config.ParseIPOrPrefix(ip)
+		if err != nil {
+			return nil, err
+		}
+		r.allow = append(r.allow, prefix)
+	}
+	return r, nil
+}
+
+func (r *Reflector) Run(ctx context.Context) error {
+	var lc net.ListenConfig
+	pc, err := lc.ListenPacket(ctx, "udp", r.cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("twamp reflector: %w", err)
+	}
+	conn := pc.(*net.UDPConn)
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	// The TTL the test packets arrived with is reflected back to the sender.
+	// It is only available where the platform reports it, and 255 otherwise.
+	_ = ipv4.NewPacketConn(conn).SetControlMessage(ipv4.FlagTTL, true)
+	_ = ipv6.NewPacketConn(conn).SetControlMessage(ipv6.FlagHopLimit, true)
+	oob := make([]byte, len(ipv4.NewControlMessage(ipv4.FlagTTL))+len(ipv6.NewControlMessage(ipv6.FlagHopLimit)))
+
+	reflected := r.metrics.Reflected.WithLabelValues(r.cfg.Listen)
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, oobn, _, from, err := conn.ReadMsgUDPAddrPort(buf, oob)
+		received := time.Now()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return ctx.Err()
+			}
+			klog.V(4).Infof("twamp reflector read failed: %v", err)
+			continue
+		}
+		// A reflected packet is never larger than the one it answers, so the
+		// reflector cannot be used to amplify traffic.
+		if n < packetSize || !r.allowed(from.Addr()) {
+			continue
+		}
+
+		synced, estimate := clockState()
+		synced = synced || r.cfg.ClockSynchronized
+		reply := reflectorPacket{
+			sender:   unmarshalSender(buf[:n]),
+			received: newTimestamp(received),
+			ttl:      receivedTTL(oob[:oobn]),
+		}
+		reply.seq = reply.sender.seq
+		reply.estimate = newErrorEstimate(synced, estimate)
+		reply.sent = newTimestamp(time.Now())
+		reply.marshal(buf[:n])
+
+		if _, err := conn.WriteToUDPAddrPort(buf[:n], from); err != nil {
+			klog.V(4).Infof("twamp reflector failed to answer %s: %v", from, err)
+			continue
+		}
+		reflected.Inc()
+	}
+}
+
+// allowed reports whether addr is one of the senders to answer. An empty
+// allow list answers nobody, so a misconfigured reflector is never open.
+func (r *Reflector) allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range r.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func receivedTTL(oob []byte) uint8 {
+	var cm4 ipv4.ControlMessage
+	if cm4.Parse(oob) == nil && cm4.TTL > 0 {
+		return uint8(cm4.TTL)
+	}
+	var cm6 ipv6.ControlMessage
+	if cm6.Parse(oob) == nil && cm6.HopLimit > 0 {
+		return uint8(cm6.HopLimit)
+	}
+	return 255
+}